@@ -0,0 +1,12 @@
+package main
+
+import (
+	"go.fuhry.dev/osquery/extcommon"
+	_ "go.fuhry.dev/osquery/flatpak"
+	_ "go.fuhry.dev/osquery/pacman"
+	_ "go.fuhry.dev/osquery/x509_certificates"
+)
+
+func main() {
+	extcommon.MainRegistered("all")
+}
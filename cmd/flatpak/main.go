@@ -2,9 +2,9 @@ package main
 
 import (
 	"go.fuhry.dev/osquery/extcommon"
-	"go.fuhry.dev/osquery/flatpak"
+	_ "go.fuhry.dev/osquery/flatpak"
 )
 
 func main() {
-	extcommon.Main("flatpak_packages", flatpak.Schema, flatpak.Generate)
+	extcommon.MainRegistered("flatpak_packages")
 }
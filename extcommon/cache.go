@@ -0,0 +1,118 @@
+package extcommon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+const resultCacheSize = 64
+
+// CacheSpec configures caching of a table's results. Results are cached for the table's interval,
+// which defaults to the --interval flag, separately for each distinct set of constraints and used
+// columns.
+type CacheSpec struct {
+	// Stamp returns a value which changes whenever the data the query reads may have changed, e.g.
+	// the modification times of the files it reads. Cached results are discarded when it changes.
+	// If nil, results are only invalidated by their TTL.
+	Stamp func(table.QueryContext) (string, error)
+}
+
+type resultCache struct {
+	spec    *CacheSpec
+	ttl     func() time.Duration
+	stats   *tableStats
+	entries *lru.Cache[string, cacheEntry]
+}
+
+type cacheEntry struct {
+	rows    []map[string]string
+	stamp   string
+	expires time.Time
+}
+
+// newResultCache returns a cache whose entries expire after ttl, which is called for each query so
+// that it may change at runtime. Results aren't cached if it returns 0 or less. Cache hits and
+// misses are recorded in stats.
+func newResultCache(spec *CacheSpec, ttl func() time.Duration, stats *tableStats) *resultCache {
+	entries, err := lru.New[string, cacheEntry](resultCacheSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &resultCache{spec, ttl, stats, entries}
+}
+
+// wrap returns a generator which returns the cached results of a query if they're still valid,
+// and otherwise calls g and caches its results.
+func (c *resultCache) wrap(g GenerateFunc) GenerateFunc {
+	return func(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
+		ttl := c.ttl()
+		if ttl <= 0 {
+			return g(ctx, q)
+		}
+
+		key, err := cacheKeyFor(ctx, q)
+		if err != nil {
+			return g(ctx, q)
+		}
+
+		var stamp string
+		if c.spec.Stamp != nil {
+			if stamp, err = c.spec.Stamp(q); err != nil {
+				Logger(ctx).Warn("not caching results: failed to compute cache stamp", "error", err)
+				return g(ctx, q)
+			}
+		}
+
+		if e, ok := c.entries.Get(key); ok && e.stamp == stamp && time.Now().Before(e.expires) {
+			c.stats.recordCache(true)
+			return e.rows, nil
+		}
+		c.stats.recordCache(false)
+
+		rows, err := g(ctx, q)
+		if err == nil {
+			c.entries.Add(key, cacheEntry{rows, stamp, time.Now().Add(ttl)})
+		}
+		return rows, err
+	}
+}
+
+// cacheKeyFor returns a string which uniquely identifies the constraints and used columns of a
+// query.
+func cacheKeyFor(ctx context.Context, q table.QueryContext) (string, error) {
+	columns, ok := UsedColumns(ctx)
+	if ok {
+		slices.Sort(columns)
+	}
+	// map keys are sorted when marshalled, so equivalent queries have the same key
+	b, err := json.Marshal(struct {
+		Constraints map[string]table.ConstraintList
+		Columns     []string
+		AllColumns  bool
+	}{q.Constraints, columns, !ok})
+	return string(b), err
+}
+
+// FileStamp returns a cache stamp which changes whenever any of the given files or directories
+// are modified, created or removed.
+func FileStamp(paths ...string) (string, error) {
+	var b strings.Builder
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(&b, "%s:missing\n", p)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", p, st.ModTime().UnixNano(), st.Size())
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,57 @@
+package extcommon
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCache(t *testing.T) {
+	defer func(d time.Duration) { Interval = d }(Interval)
+	Interval = time.Hour
+
+	stampFile := path.Join(t.TempDir(), "stamp")
+	assert.NoError(t, os.WriteFile(stampFile, []byte("a"), 0644))
+
+	calls := 0
+	stats := &tableStats{}
+	g := newResultCache(&CacheSpec{
+		Stamp: func(table.QueryContext) (string, error) { return FileStamp(stampFile) },
+	}, func() time.Duration { return Interval }, stats).wrap(func(context.Context, table.QueryContext) ([]map[string]string, error) {
+		calls++
+		return nil, nil
+	})
+
+	q1 := table.QueryContext{Constraints: map[string]table.ConstraintList{
+		"name": {Constraints: []table.Constraint{{Operator: table.OperatorEquals, Expression: "a"}}},
+	}}
+	q2 := table.QueryContext{Constraints: map[string]table.ConstraintList{
+		"name": {Constraints: []table.Constraint{{Operator: table.OperatorEquals, Expression: "b"}}},
+	}}
+	ctx := context.Background()
+
+	_, _ = g(ctx, q1)
+	_, _ = g(ctx, q1)
+	assert.Equal(t, 1, calls, "identical query should be cached")
+
+	_, _ = g(ctx, q2)
+	assert.Equal(t, 2, calls, "query with different constraints should not be cached")
+
+	_, _ = g(WithUsedColumns(ctx, []string{"name"}), q1)
+	assert.Equal(t, 3, calls, "query with different columns should not be cached")
+
+	assert.NoError(t, os.WriteFile(stampFile, []byte("bb"), 0644))
+	_, _ = g(ctx, q1)
+	assert.Equal(t, 4, calls, "cache should be invalidated when the stamp changes")
+	assert.Equal(t, int64(1), stats.cacheHits)
+	assert.Equal(t, int64(4), stats.cacheMisses)
+
+	Interval = 0
+	_, _ = g(ctx, q1)
+	assert.Equal(t, 5, calls, "cache should be disabled when interval is 0")
+}
@@ -0,0 +1,299 @@
+package extcommon
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// Value is a single typed value extracted from a row source.
+type Value interface {
+	// String serializes the value the way osquery expects to receive it in a row.
+	String() string
+	// Matches reports whether the value satisfies a single constraint.
+	Matches(table.Constraint) (bool, error)
+}
+
+// TextValue is the value of a TEXT column.
+type TextValue struct {
+	V string
+	// Compare orders two values for the <, <=, > and >= operators. If nil, values are compared
+	// lexicographically.
+	Compare func(a, b string) int
+}
+
+// IntValue is the value of an INTEGER or BIGINT column.
+type IntValue int64
+
+// BoolValue is the value of a boolean column, which osquery represents as an INTEGER of 0 or 1.
+type BoolValue bool
+
+type nullValue struct{}
+
+// Null is the value of a column which has no value for a given row.
+var Null Value = nullValue{}
+
+// Column describes a single column of a table whose rows are generated from values of type T.
+type Column[T any] interface {
+	// Name returns the name of the column.
+	Name() string
+	// Definition returns the osquery column definition used in the table schema.
+	Definition() table.ColumnDefinition
+	// Value extracts the column's value from the row source.
+	Value(T) Value
+	// Description documents the column's contents.
+	Description() string
+	// Options returns the osquery options of the column.
+	Options() ColumnOptions
+}
+
+// ColumnOptions are the options of a column, which tell osquery how to plan queries against the
+// table. Their values are those of osquery's ColumnOptions.
+type ColumnOptions int
+
+const (
+	ColumnDefault ColumnOptions = 0
+	// ColumnIndex marks a column which is used to look up rows efficiently.
+	ColumnIndex ColumnOptions = 1 << (iota - 1)
+	// ColumnRequired marks a column which queries must constrain.
+	ColumnRequired
+	// ColumnAdditional marks a column which, when constrained, generates rows that wouldn't be
+	// generated otherwise.
+	ColumnAdditional
+	// ColumnOptimized marks a column whose constraints make the generator cheaper.
+	ColumnOptimized
+	// ColumnHidden marks a column which isn't returned by "SELECT *".
+	ColumnHidden
+)
+
+var columnOptionNames = []struct {
+	o    ColumnOptions
+	name string
+}{
+	{ColumnIndex, "index"},
+	{ColumnRequired, "required"},
+	{ColumnAdditional, "additional"},
+	{ColumnOptimized, "optimized"},
+	{ColumnHidden, "hidden"},
+}
+
+// Names returns the lowercase names of the options which are set, as used in osquery's table
+// specs.
+func (o ColumnOptions) Names() (out []string) {
+	for _, n := range columnOptionNames {
+		if o&n.o != 0 {
+			out = append(out, n.name)
+		}
+	}
+	return
+}
+
+func (o ColumnOptions) String() string {
+	if o == ColumnDefault {
+		return "default"
+	}
+	return strings.Join(o.Names(), "|")
+}
+
+// ColumnInfo is the definition of a column along with its documentation.
+type ColumnInfo struct {
+	table.ColumnDefinition
+	Description string
+	Options     ColumnOptions
+}
+
+// ColumnDef is the standard implementation of Column.
+type ColumnDef[T any] struct {
+	def  table.ColumnDefinition
+	get  func(T) Value
+	desc string
+	opts ColumnOptions
+}
+
+// Table is a list of columns which together define an osquery table whose rows are generated
+// from values of type T.
+type Table[T any] []Column[T]
+
+// NewColumn returns a column with the given definition whose value is extracted with get.
+func NewColumn[T any](def table.ColumnDefinition, get func(T) Value) *ColumnDef[T] {
+	return &ColumnDef[T]{def: def, get: get}
+}
+
+// TextColumn returns a TEXT column whose value is extracted with get.
+func TextColumn[T any](name string, get func(T) string) *ColumnDef[T] {
+	return NewColumn(table.TextColumn(name), func(v T) Value { return TextValue{V: get(v)} })
+}
+
+// IntegerColumn returns an INTEGER column whose value is extracted with get.
+func IntegerColumn[T any](name string, get func(T) int64) *ColumnDef[T] {
+	return NewColumn(table.IntegerColumn(name), func(v T) Value { return IntValue(get(v)) })
+}
+
+// BigIntColumn returns a BIGINT column whose value is extracted with get.
+func BigIntColumn[T any](name string, get func(T) int64) *ColumnDef[T] {
+	return NewColumn(table.BigIntColumn(name), func(v T) Value { return IntValue(get(v)) })
+}
+
+// BoolColumn returns an INTEGER column which is set to 1 if get returns true and 0 otherwise.
+func BoolColumn[T any](name string, get func(T) bool) *ColumnDef[T] {
+	return NewColumn(table.IntegerColumn(name), func(v T) Value { return BoolValue(get(v)) })
+}
+
+// CompareWith sets the function used to order the values of a TEXT column, e.g. to compare
+// version numbers. It has no effect on columns of other types.
+func (c *ColumnDef[T]) CompareWith(cmp func(a, b string) int) *ColumnDef[T] {
+	get := c.get
+	c.get = func(v T) Value {
+		val := get(v)
+		if tv, ok := val.(TextValue); ok {
+			tv.Compare = cmp
+			return tv
+		}
+		return val
+	}
+	return c
+}
+
+// Describe sets the description of the column, which is included in the generated
+// documentation of the table.
+func (c *ColumnDef[T]) Describe(desc string) *ColumnDef[T] {
+	c.desc = desc
+	return c
+}
+
+// WithOptions sets the osquery options of the column.
+func (c *ColumnDef[T]) WithOptions(opts ColumnOptions) *ColumnDef[T] {
+	c.opts = opts
+	return c
+}
+
+// Name implements Column
+func (c *ColumnDef[T]) Name() string {
+	return c.def.Name
+}
+
+// Definition implements Column
+func (c *ColumnDef[T]) Definition() table.ColumnDefinition {
+	return c.def
+}
+
+// Value implements Column
+func (c *ColumnDef[T]) Value(v T) Value {
+	return c.get(v)
+}
+
+// Description implements Column
+func (c *ColumnDef[T]) Description() string {
+	return c.desc
+}
+
+// Options implements Column
+func (c *ColumnDef[T]) Options() ColumnOptions {
+	return c.opts
+}
+
+// Schema returns the osquery schema of the table.
+func (t Table[T]) Schema() (out []table.ColumnDefinition) {
+	for _, c := range t {
+		out = append(out, c.Definition())
+	}
+	return
+}
+
+// Columns returns the definitions of the table's columns along with their documentation.
+func (t Table[T]) Columns() (out []ColumnInfo) {
+	for _, c := range t {
+		out = append(out, ColumnInfo{c.Definition(), c.Description(), c.Options()})
+	}
+	return
+}
+
+// ForQuery returns the columns of the table which are needed by a query. Generators should call
+// this once per query and use the result to generate rows, so that columns which the query
+// doesn't use aren't computed.
+func (t Table[T]) ForQuery(ctx context.Context, q table.QueryContext) Table[T] {
+	var out Table[T]
+	for _, c := range t {
+		if ColumnNeeded(ctx, q, c.Name()) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Matches evaluates the query's constraints on the named columns against v. If no columns are
+// named, the constraints on every column are evaluated.
+func (t Table[T]) Matches(q table.QueryContext, v T, columns ...string) (bool, error) {
+	for _, c := range t {
+		if len(columns) > 0 && !slices.Contains(columns, c.Name()) {
+			continue
+		}
+		if constraints, ok := q.Constraints[c.Name()]; ok {
+			if m, err := MatchConstraints(c.Value(v), constraints); !m {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// Row evaluates the query's constraints against v and, if they are all satisfied, serializes it
+// to an osquery row. ok is false if v was filtered out by the constraints. The row's keys are the
+// names of the column definitions, so they're shared by every row rather than allocated per row.
+func (t Table[T]) Row(q table.QueryContext, v T) (row map[string]string, ok bool, err error) {
+	// evaluate the constraints before allocating the row, so that rows which are filtered out
+	// don't allocate anything for most tables
+	var buf [32]Value
+	vals := buf[:0]
+	for _, c := range t {
+		val := c.Value(v)
+		if constraints, found := q.Constraints[c.Name()]; found {
+			if m, err := MatchConstraints(val, constraints); !m {
+				return nil, false, err
+			}
+		}
+		vals = append(vals, val)
+	}
+
+	row = make(map[string]string, len(t))
+	for i, c := range t {
+		row[c.Name()] = vals[i].String()
+	}
+	return row, true, nil
+}
+
+// Yield passes the row serialized from v to yield if it satisfies the query's constraints. It
+// returns false once yield asks the generator to stop, or if evaluating the constraints fails.
+func (t Table[T]) Yield(q table.QueryContext, v T, yield YieldFunc) (bool, error) {
+	row, ok, err := t.Row(q, v)
+	if !ok {
+		return err == nil, err
+	}
+	return yield(row), nil
+}
+
+// String implements Value
+func (v TextValue) String() string {
+	return v.V
+}
+
+// String implements Value
+func (v IntValue) String() string {
+	return strconv.FormatInt(int64(v), 10)
+}
+
+// String implements Value
+func (v BoolValue) String() string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// String implements Value
+func (nullValue) String() string {
+	return ""
+}
@@ -0,0 +1,139 @@
+package extcommon
+
+import (
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRow struct {
+	name    string
+	size    int64
+	enabled bool
+}
+
+var testTable = Table[testRow]{
+	TextColumn("name", func(r testRow) string { return r.name }),
+	BigIntColumn("size", func(r testRow) int64 { return r.size }),
+	BoolColumn("enabled", func(r testRow) bool { return r.enabled }),
+}
+
+func constraints(c ...table.Constraint) table.ConstraintList {
+	return table.ConstraintList{Constraints: c}
+}
+
+func TestTableSchema(t *testing.T) {
+	assert.Equal(t, []table.ColumnDefinition{
+		table.TextColumn("name"),
+		table.BigIntColumn("size"),
+		table.IntegerColumn("enabled"),
+	}, testTable.Schema())
+}
+
+func TestTableRow(t *testing.T) {
+	type testCase struct {
+		name        string
+		constraints map[string]table.ConstraintList
+		expectOk    bool
+	}
+
+	v := testRow{"foo", 1024, true}
+
+	var testCases = []*testCase{
+		{
+			name:     "no constraints",
+			expectOk: true,
+		},
+		{
+			name: "text equals",
+			constraints: map[string]table.ConstraintList{
+				"name": constraints(table.Constraint{Operator: table.OperatorEquals, Expression: "foo"}),
+			},
+			expectOk: true,
+		},
+		{
+			name: "text not equals",
+			constraints: map[string]table.ConstraintList{
+				"name": constraints(table.Constraint{Operator: table.OperatorEquals, Expression: "bar"}),
+			},
+			expectOk: false,
+		},
+		{
+			name: "text in",
+			constraints: map[string]table.ConstraintList{
+				"name": constraints(
+					table.Constraint{Operator: table.OperatorEquals, Expression: "bar"},
+					table.Constraint{Operator: table.OperatorEquals, Expression: "foo"},
+				),
+			},
+			expectOk: true,
+		},
+		{
+			name: "int range",
+			constraints: map[string]table.ConstraintList{
+				"size": constraints(
+					table.Constraint{Operator: table.OperatorGreaterThan, Expression: "1000"},
+					table.Constraint{Operator: table.OperatorLessThanOrEquals, Expression: "1024"},
+				),
+			},
+			expectOk: true,
+		},
+		{
+			name: "int range excluded",
+			constraints: map[string]table.ConstraintList{
+				"size": constraints(
+					table.Constraint{Operator: table.OperatorGreaterThan, Expression: "1000"},
+					table.Constraint{Operator: table.OperatorLessThan, Expression: "1024"},
+				),
+			},
+			expectOk: false,
+		},
+		{
+			name: "bool",
+			constraints: map[string]table.ConstraintList{
+				"enabled": constraints(table.Constraint{Operator: table.OperatorEquals, Expression: "1"}),
+			},
+			expectOk: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			row, ok, err := testTable.Row(table.QueryContext{Constraints: tc.constraints}, v)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectOk, ok)
+			if !tc.expectOk {
+				return
+			}
+
+			assert.Equal(t, map[string]string{"name": "foo", "size": "1024", "enabled": "1"}, row)
+		})
+	}
+}
+
+func TestCompareWith(t *testing.T) {
+	byLength := func(a, b string) int { return len(a) - len(b) }
+	tbl := Table[string]{
+		TextColumn("v", func(s string) string { return s }).CompareWith(byLength),
+	}
+	q := table.QueryContext{Constraints: map[string]table.ConstraintList{
+		"v": constraints(table.Constraint{Operator: table.OperatorGreaterThan, Expression: "aaa"}),
+	}}
+
+	m, err := tbl.Matches(q, "zz")
+	assert.NoError(t, err)
+	assert.False(t, m)
+
+	m, err = tbl.Matches(q, "aaaa")
+	assert.NoError(t, err)
+	assert.True(t, m)
+}
+
+func TestColumnOptions(t *testing.T) {
+	assert.Equal(t, ColumnOptions(16), ColumnHidden)
+	assert.Equal(t, "default", ColumnDefault.String())
+	assert.Equal(t, "index|required", (ColumnRequired | ColumnIndex).String())
+	assert.Equal(t, []string{"additional", "optimized", "hidden"},
+		(ColumnAdditional | ColumnOptimized | ColumnHidden).Names())
+}
@@ -0,0 +1,80 @@
+package extcommon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// semaphores limit the concurrency of each group of tables. They're kept across reconnections
+	// to osquery, since a generator may still be running when the extension reconnects.
+	semaphores   = map[string]*semaphore.Weighted{}
+	semaphoresMu sync.Mutex
+)
+
+// semaphoreFor returns the semaphore limiting the concurrency of a group of tables. The limit is
+// that of the first table of the group to be served.
+func semaphoreFor(group string, n int) *semaphore.Weighted {
+	semaphoresMu.Lock()
+	defer semaphoresMu.Unlock()
+
+	s, ok := semaphores[group]
+	if !ok {
+		s = semaphore.NewWeighted(int64(n))
+		semaphores[group] = s
+	}
+	return s
+}
+
+// limitConcurrency returns a generator which runs at most t.MaxConcurrency generations of g at
+// once among the tables of its concurrency group, waiting until the query's context is done for
+// its turn. A generation keeps its slot until g returns, even if its query was abandoned after
+// timing out, so that g never runs more often at once than allowed; queries waiting behind it
+// fail once their own context is done.
+func limitConcurrency(name string, t TableSpec, g GenerateFunc) GenerateFunc {
+	if t.MaxConcurrency <= 0 {
+		return g
+	}
+	group := t.ConcurrencyGroup
+	if group == "" {
+		group = name
+	}
+	sem := semaphoreFor(group, t.MaxConcurrency)
+
+	return func(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			return nil, fmt.Errorf("waiting for a turn to query tables of concurrency group %q: %w", group, err)
+		}
+		defer sem.Release(1)
+		return g(ctx, q)
+	}
+}
+
+// coalesce returns a generator which shares a single generation of g among identical queries
+// which are in flight at the same time. The generation runs with the context of the first query,
+// so the others fail if it times out.
+func coalesce(g GenerateFunc) GenerateFunc {
+	var group singleflight.Group
+
+	return func(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
+		key, err := cacheKeyFor(ctx, q)
+		if err != nil {
+			return g(ctx, q)
+		}
+
+		ch := group.DoChan(key, func() (any, error) {
+			return g(ctx, q)
+		})
+		r := <-ch
+		if r.Shared {
+			Logger(ctx).Debug("coalesced query with an identical query in flight")
+		}
+		rows, _ := r.Val.([]map[string]string)
+		return rows, r.Err
+	}
+}
@@ -0,0 +1,154 @@
+package extcommon
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingGenerate returns a generator which counts its calls and the maximum number of calls
+// running at once, and blocks until release is closed.
+func blockingGenerate(calls, maxRunning *int32, release <-chan struct{}) GenerateFunc {
+	var running int32
+	return func(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
+		atomic.AddInt32(calls, 1)
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(maxRunning, m, n) {
+				break
+			}
+		}
+		select {
+		case <-release:
+			return []map[string]string{{"name": "foo"}}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func runConcurrently(n int, g GenerateFunc) (rows [][]map[string]string) {
+	rows = make([][]map[string]string, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows[i], _ = g(context.Background(), table.QueryContext{})
+		}()
+	}
+	wg.Wait()
+	return
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	var calls, maxRunning int32
+	release := make(chan struct{})
+	spec := TableSpec{MaxConcurrency: 2, ConcurrencyGroup: "test_limit_concurrency"}
+	t.Cleanup(func() {
+		semaphoresMu.Lock()
+		delete(semaphores, spec.ConcurrencyGroup)
+		semaphoresMu.Unlock()
+	})
+
+	// two tables sharing a group share its limit
+	g := blockingGenerate(&calls, &maxRunning, release)
+	a, b := limitConcurrency("a", spec, g), limitConcurrency("b", spec, g)
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	done := make(chan struct{})
+	go func() {
+		runConcurrently(3, a)
+		close(done)
+	}()
+	runConcurrently(3, b)
+	<-done
+
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(2), maxRunning)
+
+	// waiting for a turn gives up once the query's context is done
+	release = make(chan struct{})
+	defer close(release)
+	a = limitConcurrency("a", spec, blockingGenerate(&calls, &maxRunning, release))
+	go a(context.Background(), table.QueryContext{})
+	go a(context.Background(), table.QueryContext{})
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := a(ctx, table.QueryContext{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(8), atomic.LoadInt32(&calls))
+}
+
+func TestCoalesce(t *testing.T) {
+	var calls, maxRunning int32
+	release := make(chan struct{})
+	g := coalesce(blockingGenerate(&calls, &maxRunning, release))
+
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	rows := runConcurrently(5, g)
+
+	assert.Equal(t, int32(1), calls)
+	for _, r := range rows {
+		assert.Equal(t, []map[string]string{{"name": "foo"}}, r)
+	}
+
+	// queries after the generation finished generate again
+	_, err := g(context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestLimitConcurrencyAbandoned(t *testing.T) {
+	defer func(d time.Duration) { abandonAfter = d }(abandonAfter)
+	abandonAfter = 10 * time.Millisecond
+
+	group := "test_limit_concurrency_abandoned"
+	t.Cleanup(func() {
+		semaphoresMu.Lock()
+		delete(semaphores, group)
+		semaphoresMu.Unlock()
+	})
+
+	// a generator which ignores its context, e.g. because it's blocked on a hung filesystem
+	release, exited := make(chan struct{}), make(chan struct{})
+	hung := wrapGenerate("test_hung", TableSpec{
+		Timeout: 20 * time.Millisecond, MaxConcurrency: 1, ConcurrencyGroup: group,
+		Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+			defer close(exited)
+			<-release
+			return nil, nil
+		},
+	})
+	other := wrapGenerate("test_other", TableSpec{
+		Timeout: 20 * time.Millisecond, MaxConcurrency: 1, ConcurrencyGroup: group,
+		Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+			return []map[string]string{{"name": "foo"}}, nil
+		},
+	})
+
+	_, err := hung(context.Background(), table.QueryContext{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the abandoned generator still holds the group's slot, and a query waiting for it fails
+	// at its own deadline rather than when the generator returns
+	start := time.Now()
+	_, err = other(context.Background(), table.QueryContext{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "concurrency group")
+	assert.Less(t, time.Since(start), time.Second)
+
+	// the slot is released once the generator returns
+	close(release)
+	<-exited
+	rows, err := other(context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+}
@@ -0,0 +1,291 @@
+package extcommon
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/osquery/osquery-go/plugin/table"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnv is the environment variable which names the config file if the --config flag isn't
+// set.
+const ConfigEnv = "OSQUERY_EXTENSION_CONFIG"
+
+// ConfigTableName returns the name of the table exposing the effective configuration of the named
+// extension. It's named after the extension so that several extensions can be served by the same
+// osqueryd.
+func ConfigTableName(extension string) string {
+	return extension + "_extension_config"
+}
+
+// configTablesKey is the key of the config file section holding per-table options.
+const configTablesKey = "tables"
+
+const (
+	ConfigSourceDefault = "default"
+	ConfigSourceFile    = "config"
+	ConfigSourceFlag    = "flag"
+)
+
+// config is the contents of a config file. Keys other than "tables" name flags, either directly
+// or by nesting, so that "pacman.db-path" may also be written as "db-path" in a "pacman" section.
+// The "tables" section holds the options of each table, keyed by table name.
+type config struct {
+	flags  map[string]string
+	tables map[string]map[string]any
+}
+
+// configSources records where the value of each flag and table option came from.
+type configSources map[string]string
+
+// loadConfig reads a config file, whose format is determined by its extension.
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".json":
+		err = json.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file format %q", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	c := &config{flags: map[string]string{}, tables: map[string]map[string]any{}}
+	if tables, ok := raw[configTablesKey]; ok {
+		delete(raw, configTablesKey)
+		m, ok := tables.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %q must be a map of table names to options", path, configTablesKey)
+		}
+		for name, opts := range m {
+			if c.tables[name], ok = opts.(map[string]any); !ok {
+				return nil, fmt.Errorf("%s: options of table %q must be a map", path, name)
+			}
+		}
+	}
+	if err := flattenConfig(c.flags, "", raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// flattenConfig joins the keys of nested maps with dots and serializes their values as flag
+// values. Lists are joined with commas.
+func flattenConfig(out map[string]string, prefix string, m map[string]any) error {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]any); ok {
+			if err := flattenConfig(out, k, sub); err != nil {
+				return err
+			}
+			continue
+		}
+		s, err := configString(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+		out[k] = s
+	}
+	return nil
+}
+
+func configString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool, int, int64, uint64:
+		return fmt.Sprint(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := configString(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// apply sets every flag named in the config which wasn't set on the command line, and applies
+// the table options to t. Keys naming flags which aren't registered are ignored, since a config
+// file may be shared by extensions serving different tables; so are options of tables which
+// aren't served.
+func (c *config) apply(fs *flag.FlagSet, t Tables) (configSources, error) {
+	sources := configSources{}
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = ConfigSourceFlag
+	})
+
+	keys := make([]string, 0, len(c.flags))
+	for k := range c.flags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if _, ok := sources[k]; ok {
+			continue
+		}
+		if fs.Lookup(k) == nil {
+			slog.Info("ignoring config key: no such flag", "key", k)
+			continue
+		}
+		if err := fs.Set(k, c.flags[k]); err != nil {
+			return nil, fmt.Errorf("invalid value for config key %q: %v", k, err)
+		}
+		sources[k] = ConfigSourceFile
+	}
+
+	for name, opts := range c.tables {
+		spec, ok := t[name]
+		if !ok {
+			continue
+		}
+		for k, v := range opts {
+			if err := spec.setOption(k, v); err != nil {
+				return nil, fmt.Errorf("invalid option %q of table %q: %v", k, name, err)
+			}
+			sources[tableOptionKey(name, k)] = ConfigSourceFile
+		}
+		if spec.Disabled {
+			delete(t, name)
+		} else {
+			t[name] = spec
+		}
+	}
+	return sources, nil
+}
+
+// setOption sets an option of the table from the config file.
+func (t *TableSpec) setOption(k string, v any) (err error) {
+	s, err := configString(v)
+	if err != nil {
+		return err
+	}
+	switch k {
+	case "timeout":
+		return durationParser(&t.Timeout)(s)
+	case "interval":
+		return durationParser(&t.Interval)(s)
+	case "partial_results":
+		t.PartialResults, err = strconv.ParseBool(s)
+	case "max_rows":
+		t.MaxRows, err = strconv.Atoi(s)
+	case "max_bytes":
+		t.MaxBytes, err = strconv.ParseInt(s, 10, 64)
+	case "max_concurrency":
+		t.MaxConcurrency, err = strconv.Atoi(s)
+	case "coalesce":
+		t.Coalesce, err = strconv.ParseBool(s)
+	case "enabled":
+		var enabled bool
+		enabled, err = strconv.ParseBool(s)
+		t.Disabled = !enabled
+	default:
+		return fmt.Errorf("no such option")
+	}
+	return err
+}
+
+// tableOptions returns the effective values of the options of a table.
+func (t TableSpec) tableOptions() [][2]string {
+	return [][2]string{
+		{"coalesce", strconv.FormatBool(t.Coalesce)},
+		{"enabled", strconv.FormatBool(!t.Disabled)},
+		{"interval", t.interval().String()},
+		{"max_bytes", strconv.FormatInt(t.maxBytes(), 10)},
+		{"max_concurrency", strconv.Itoa(t.MaxConcurrency)},
+		{"max_rows", strconv.Itoa(t.maxRows())},
+		{"partial_results", strconv.FormatBool(t.PartialResults)},
+		{"timeout", t.timeout().String()},
+	}
+}
+
+func tableOptionKey(table, option string) string {
+	return configTablesKey + "." + table + "." + option
+}
+
+// configEntry is a row of the config table.
+type configEntry struct {
+	name, value, defaultValue, source string
+}
+
+var configColumns = Table[configEntry]{
+	TextColumn("name", func(e configEntry) string { return e.name }).Describe(`Name of the flag, or "tables.<table>.<option>" for table options`),
+	TextColumn("value", func(e configEntry) string { return e.value }).Describe("Effective value"),
+	TextColumn("default_value", func(e configEntry) string { return e.defaultValue }).Describe("Default value of the flag"),
+	TextColumn("source", func(e configEntry) string { return e.source }).Describe(`Where the value came from: "default", "config" or "flag"`),
+}
+
+// configTable returns a table listing the effective value of every flag and every option of the
+// tables in t, and whether each was set by a flag, the config file or is the default.
+func configTable(fs *flag.FlagSet, t Tables, sources configSources) TableSpec {
+	source := func(k string) string {
+		if s, ok := sources[k]; ok {
+			return s
+		}
+		return ConfigSourceDefault
+	}
+
+	return TableSpec{
+		Description: "Effective value of each flag of the extension and option of its tables.",
+		Columns:     configColumns.Columns,
+		Generate: func(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
+			var entries []configEntry
+			fs.VisitAll(func(f *flag.Flag) {
+				entries = append(entries, configEntry{f.Name, f.Value.String(), f.DefValue, source(f.Name)})
+			})
+
+			names := make([]string, 0, len(t))
+			for name := range t {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			for _, name := range names {
+				for _, opt := range t[name].tableOptions() {
+					k := tableOptionKey(name, opt[0])
+					entries = append(entries, configEntry{k, opt[1], "", source(k)})
+				}
+			}
+
+			cols := configColumns.ForQuery(ctx, q)
+			for _, e := range entries {
+				if row, ok, err := cols.Row(q, e); err != nil {
+					return nil, err
+				} else if ok {
+					out = append(out, row)
+				}
+			}
+			return
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package extcommon
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	testCases := []struct {
+		file     string
+		contents string
+	}{
+		{"config.yaml", `
+socket: /var/osquery/osquery.em
+pacman:
+  db-path: /srv/pacman
+enable: [pacman_*, x509_*]
+tables:
+  pacman_packages:
+    timeout: 30s
+    partial_results: true
+`},
+		{"config.json", `{
+  "socket": "/var/osquery/osquery.em",
+  "pacman.db-path": "/srv/pacman",
+  "enable": ["pacman_*", "x509_*"],
+  "tables": {"pacman_packages": {"timeout": 30, "partial_results": true}}
+}`},
+		{"config.toml", `
+socket = "/var/osquery/osquery.em"
+enable = ["pacman_*", "x509_*"]
+
+[pacman]
+db-path = "/srv/pacman"
+
+[tables.pacman_packages]
+timeout = "30s"
+partial_results = true
+`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			p := path.Join(t.TempDir(), tc.file)
+			assert.NoError(t, os.WriteFile(p, []byte(tc.contents), 0644))
+
+			c, err := loadConfig(p)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, map[string]string{
+				"socket":         "/var/osquery/osquery.em",
+				"pacman.db-path": "/srv/pacman",
+				"enable":         "pacman_*,x509_*",
+			}, c.flags)
+
+			tables := Tables{"pacman_packages": {}}
+			_, err = c.apply(flag.NewFlagSet("test", flag.ContinueOnError), tables)
+			assert.NoError(t, err)
+			assert.Equal(t, 30*time.Second, tables["pacman_packages"].Timeout)
+			assert.True(t, tables["pacman_packages"].PartialResults)
+		})
+	}
+
+	_, err := loadConfig(path.Join(t.TempDir(), "config.ini"))
+	assert.Error(t, err)
+}
+
+func TestConfigApply(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	socket := fs.String("socket", "/default.em", "")
+	dbPath := fs.String("pacman.db-path", "/var/lib/pacman", "")
+	assert.NoError(t, fs.Parse([]string{"--socket=/flag.em"}))
+
+	c := &config{
+		flags: map[string]string{
+			"socket":             "/config.em",
+			"pacman.db-path":     "/srv/pacman",
+			"flatpak.system-dir": "/srv/flatpak",
+		},
+		tables: map[string]map[string]any{
+			"a":       {"interval": "1m"},
+			"b":       {"enabled": false},
+			"missing": {"timeout": "1s"},
+		},
+	}
+	tables := Tables{"a": {}, "b": {}}
+	sources, err := c.apply(fs, tables)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/flag.em", *socket, "flags take precedence over the config file")
+	assert.Equal(t, "/srv/pacman", *dbPath)
+	assert.Equal(t, configSources{
+		"socket":            ConfigSourceFlag,
+		"pacman.db-path":    ConfigSourceFile,
+		"tables.a.interval": ConfigSourceFile,
+		"tables.b.enabled":  ConfigSourceFile,
+	}, sources)
+	assert.Equal(t, Tables{"a": {Interval: time.Minute}}, tables)
+
+	c.tables = map[string]map[string]any{"a": {"color": "blue"}}
+	_, err = c.apply(fs, tables)
+	assert.Error(t, err, "unknown table options are rejected")
+}
+
+func TestConfigTable(t *testing.T) {
+	defer func(d time.Duration) { Timeout = d }(Timeout)
+	Timeout = 5 * time.Second
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("socket", "/default.em", "")
+	tables := Tables{"a": {Interval: time.Minute, MaxRows: 100}}
+
+	spec := configTable(fs, tables, configSources{"tables.a.interval": ConfigSourceFile})
+	rows, err := spec.Generate(context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"name": "socket", "value": "/default.em", "default_value": "/default.em", "source": "default"},
+		{"name": "tables.a.coalesce", "value": "false", "default_value": "", "source": "default"},
+		{"name": "tables.a.enabled", "value": "true", "default_value": "", "source": "default"},
+		{"name": "tables.a.interval", "value": "1m0s", "default_value": "", "source": "config"},
+		{"name": "tables.a.max_bytes", "value": "0", "default_value": "", "source": "default"},
+		{"name": "tables.a.max_concurrency", "value": "0", "default_value": "", "source": "default"},
+		{"name": "tables.a.max_rows", "value": "100", "default_value": "", "source": "default"},
+		{"name": "tables.a.partial_results", "value": "false", "default_value": "", "source": "default"},
+		{"name": "tables.a.timeout", "value": "5s", "default_value": "", "source": "default"},
+	}, rows)
+}
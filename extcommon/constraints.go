@@ -0,0 +1,310 @@
+package extcommon
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// Operators which osquery forwards to extensions, but which aren't defined by osquery-go. The
+// values match SQLite's SQLITE_INDEX_CONSTRAINT_* constants.
+const (
+	OperatorNotEquals table.Operator = 68
+	OperatorIsNot     table.Operator = 69
+	OperatorIsNotNull table.Operator = 70
+	OperatorIsNull    table.Operator = 71
+	OperatorIs        table.Operator = 72
+	// OperatorLimit and OperatorOffset carry the LIMIT and OFFSET of a query rather than a
+	// constraint on the column they're attached to.
+	OperatorLimit  table.Operator = 73
+	OperatorOffset table.Operator = 74
+)
+
+// ErrNoPushdown is returned by Value.Matches when a constraint can't be evaluated by the
+// extension. The constraint is then considered satisfied and left for osquery to apply.
+var ErrNoPushdown = errors.New("constraint cannot be evaluated by the extension")
+
+// ErrMissingRequiredColumn is returned for queries which don't constrain a column marked with
+// ColumnRequired.
+var ErrMissingRequiredColumn = errors.New("missing required column in WHERE clause")
+
+// ValidateQuery checks that a query constrains every column marked with ColumnRequired.
+func ValidateQuery(columns []ColumnInfo, q table.QueryContext) error {
+	for _, c := range columns {
+		if c.Options&ColumnRequired == 0 {
+			continue
+		}
+		cl := q.Constraints[c.Name]
+		if !slices.ContainsFunc(cl.Constraints, func(c table.Constraint) bool {
+			return c.Operator != OperatorLimit && c.Operator != OperatorOffset
+		}) {
+			return fmt.Errorf("%w: %q", ErrMissingRequiredColumn, c.Name)
+		}
+	}
+	return nil
+}
+
+// MatchConstraints reports whether val satisfies a column's constraints. Equality constraints
+// are treated as a set of allowed values, since this is how osquery passes an IN clause; all
+// other constraints must be satisfied.
+func MatchConstraints(val Value, constraints table.ConstraintList) (bool, error) {
+	var equals, matchedEquals bool
+	for _, c := range constraints.Constraints {
+		if c.Operator == OperatorLimit || c.Operator == OperatorOffset {
+			continue
+		}
+		m, err := val.Matches(c)
+		if errors.Is(err, ErrNoPushdown) {
+			m, err = true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if c.Operator == table.OperatorEquals {
+			equals = true
+			matchedEquals = matchedEquals || m
+			continue
+		}
+		if !m {
+			return false, nil
+		}
+	}
+
+	return !equals || matchedEquals, nil
+}
+
+// EqualityConstraints returns the expressions of the equality constraints on the named column,
+// i.e. the values listed in a "column = value" or "column IN (...)" clause.
+func EqualityConstraints(q table.QueryContext, column string) (out []string) {
+	for _, c := range q.Constraints[column].Constraints {
+		if c.Operator == table.OperatorEquals {
+			out = append(out, c.Expression)
+		}
+	}
+	return
+}
+
+// LowerBound returns the smallest integer value of the named column which may satisfy the query's
+// constraints, e.g. 11 for "column > 10", so that generators can skip data which can't match.
+// ok is false if the constraints don't bound the column from below.
+func LowerBound(q table.QueryContext, column string) (min int64, ok bool) {
+	var minEquals int64
+	var equals bool
+	for _, c := range q.Constraints[column].Constraints {
+		i, err := strconv.ParseInt(c.Expression, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch c.Operator {
+		case table.OperatorEquals:
+			// equality constraints are alternatives, so only the smallest bounds the column
+			if !equals || i < minEquals {
+				minEquals, equals = i, true
+			}
+			continue
+		case table.OperatorGreaterThan:
+			i++
+		case table.OperatorGreaterThanOrEquals:
+		default:
+			continue
+		}
+		if !ok || i > min {
+			min, ok = i, true
+		}
+	}
+	if equals && (!ok || minEquals > min) {
+		min, ok = minEquals, true
+	}
+	return
+}
+
+// Matches implements Value
+func (v TextValue) Matches(c table.Constraint) (bool, error) {
+	cmp := v.Compare
+	if cmp == nil {
+		cmp = strings.Compare
+	}
+	switch c.Operator {
+	case table.OperatorEquals, OperatorIs:
+		return v.V == c.Expression, nil
+	case OperatorNotEquals, OperatorIsNot:
+		return v.V != c.Expression, nil
+	case OperatorIsNull:
+		return false, nil
+	case OperatorIsNotNull:
+		return true, nil
+	case table.OperatorLike:
+		return likeMatch(c.Expression, v.V), nil
+	case table.OperatorGlob:
+		g, err := CompileGlob(sqliteGlob(c.Expression))
+		if err != nil {
+			return false, err
+		}
+		return g.Match(v.V), nil
+	case table.OperatorRegexp:
+		r, err := CompileRegexp(c.Expression)
+		if err != nil {
+			return false, err
+		}
+		return r.MatchString(v.V), nil
+	}
+	return compareOp(c.Operator, cmp(v.V, c.Expression))
+}
+
+// Matches implements Value
+func (v IntValue) Matches(c table.Constraint) (bool, error) {
+	switch c.Operator {
+	case OperatorIsNull:
+		return false, nil
+	case OperatorIsNotNull:
+		return true, nil
+	case table.OperatorLike, table.OperatorGlob, table.OperatorRegexp:
+		// SQLite converts the value to text before matching it against a pattern
+		return TextValue{V: v.String()}.Matches(c)
+	}
+
+	var cmp int
+	if i, err := strconv.ParseInt(c.Expression, 10, 64); err == nil {
+		cmp = compareNumbers(int64(v), i)
+	} else if f, err := strconv.ParseFloat(c.Expression, 64); err == nil {
+		cmp = compareNumbers(float64(v), f)
+	} else {
+		return false, ErrNoPushdown
+	}
+
+	switch c.Operator {
+	case table.OperatorEquals, OperatorIs:
+		return cmp == 0, nil
+	case OperatorNotEquals, OperatorIsNot:
+		return cmp != 0, nil
+	}
+	return compareOp(c.Operator, cmp)
+}
+
+// Matches implements Value
+func (v BoolValue) Matches(c table.Constraint) (bool, error) {
+	if e, err := parseTruthy(c.Expression); err == nil {
+		c.Expression = BoolValue(e).String()
+	}
+	i := IntValue(0)
+	if v {
+		i = 1
+	}
+	return i.Matches(c)
+}
+
+// Matches implements Value
+func (nullValue) Matches(c table.Constraint) (bool, error) {
+	switch c.Operator {
+	case OperatorIsNull, OperatorIsNot:
+		return true, nil
+	}
+	// comparing anything else to null yields null, which is false
+	return false, nil
+}
+
+// compareOp evaluates an ordering operator given the result of comparing a value to the
+// constraint's expression.
+func compareOp(op table.Operator, cmp int) (bool, error) {
+	switch op {
+	case table.OperatorGreaterThan:
+		return cmp > 0, nil
+	case table.OperatorGreaterThanOrEquals:
+		return cmp >= 0, nil
+	case table.OperatorLessThan:
+		return cmp < 0, nil
+	case table.OperatorLessThanOrEquals:
+		return cmp <= 0, nil
+	}
+	return false, fmt.Errorf("%w: unsupported operator %v", ErrNoPushdown, op)
+}
+
+func compareNumbers[N int64 | float64](a, b N) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// likeMatch reports whether s matches an SQL LIKE pattern. Like SQLite, "%" matches any sequence
+// of characters, "_" matches a single character, and only ASCII characters are case-folded.
+func likeMatch(pattern, s string) bool {
+	p, t := []rune(pattern), []rune(s)
+	pi, ti := 0, 0
+	starP, starT := -1, 0
+	for ti < len(t) {
+		switch {
+		case pi < len(p) && p[pi] == '%':
+			starP, starT = pi, ti
+			pi++
+		case pi < len(p) && (p[pi] == '_' || foldASCII(p[pi]) == foldASCII(t[ti])):
+			pi++
+			ti++
+		case starP >= 0:
+			// backtrack: let the last "%" consume one more character
+			starT++
+			pi, ti = starP+1, starT
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '%' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+func foldASCII(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + ('a' - 'A')
+	}
+	return r
+}
+
+// sqliteGlob translates an SQLite GLOB pattern to the syntax understood by CompileGlob. SQLite
+// negates character classes with "^" and has no escape character or alternation.
+func sqliteGlob(pattern string) string {
+	var b strings.Builder
+	runes := []rune(pattern)
+	inClass := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case inClass:
+			if r == ']' {
+				inClass = false
+			}
+			b.WriteRune(r)
+		case r == '[':
+			inClass = true
+			b.WriteRune(r)
+			if i+1 < len(runes) && runes[i+1] == '^' {
+				b.WriteRune('!')
+				i++
+			}
+		case r == '\\' || r == '{' || r == '}':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func parseTruthy(val string) (bool, error) {
+	switch strings.ToLower(val) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a truthy value: %q", val)
+}
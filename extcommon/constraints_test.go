@@ -0,0 +1,107 @@
+package extcommon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchConstraints(t *testing.T) {
+	type testCase struct {
+		val         Value
+		constraints []table.Constraint
+		expect      bool
+	}
+
+	c := func(op table.Operator, expr string) table.Constraint {
+		return table.Constraint{Operator: op, Expression: expr}
+	}
+
+	var testCases = []*testCase{
+		// multiple constraints are ANDed
+		{TextValue{V: "1.5"}, []table.Constraint{c(table.OperatorGreaterThan, "1"), c(table.OperatorLessThan, "2")}, true},
+		{TextValue{V: "2.5"}, []table.Constraint{c(table.OperatorGreaterThan, "1"), c(table.OperatorLessThan, "2")}, false},
+		{IntValue(5), []table.Constraint{c(table.OperatorGreaterThanOrEquals, "5"), c(OperatorNotEquals, "5")}, false},
+		// IN
+		{TextValue{V: "b"}, []table.Constraint{c(table.OperatorEquals, "a"), c(table.OperatorEquals, "b")}, true},
+		{TextValue{V: "c"}, []table.Constraint{c(table.OperatorEquals, "a"), c(table.OperatorEquals, "b")}, false},
+		{TextValue{V: "b"}, []table.Constraint{c(table.OperatorEquals, "a"), c(table.OperatorEquals, "b"), c(OperatorNotEquals, "b")}, false},
+		// LIKE
+		{TextValue{V: "Linux"}, []table.Constraint{c(table.OperatorLike, "lin%")}, true},
+		{TextValue{V: "Linux"}, []table.Constraint{c(table.OperatorLike, "l_n_x")}, true},
+		{TextValue{V: "Linux"}, []table.Constraint{c(table.OperatorLike, "%nu")}, false},
+		{TextValue{V: "Linux"}, []table.Constraint{c(table.OperatorLike, "%%n%x")}, true},
+		{TextValue{V: "ÄBC"}, []table.Constraint{c(table.OperatorLike, "äbc")}, false},
+		{IntValue(1024), []table.Constraint{c(table.OperatorLike, "10%")}, true},
+		// GLOB
+		{TextValue{V: "/usr/bin/ls"}, []table.Constraint{c(table.OperatorGlob, "/usr/*/ls")}, true},
+		{TextValue{V: "/usr/bin/ls"}, []table.Constraint{c(table.OperatorGlob, "/USR/*")}, false},
+		{TextValue{V: "a1"}, []table.Constraint{c(table.OperatorGlob, "a[^0-9]")}, false},
+		{TextValue{V: "{a}"}, []table.Constraint{c(table.OperatorGlob, "{a}")}, true},
+		// REGEXP
+		{TextValue{V: "foo123"}, []table.Constraint{c(table.OperatorRegexp, `^foo\d+$`)}, true},
+		// numeric coercion
+		{IntValue(2), []table.Constraint{c(table.OperatorLessThan, "2.5")}, true},
+		{IntValue(2), []table.Constraint{c(table.OperatorEquals, "2.0")}, true},
+		// null handling
+		{Null, []table.Constraint{c(OperatorIsNull, "")}, true},
+		{Null, []table.Constraint{c(OperatorIsNotNull, "")}, false},
+		{Null, []table.Constraint{c(table.OperatorEquals, "")}, false},
+		{TextValue{V: ""}, []table.Constraint{c(OperatorIsNull, "")}, false},
+		{IntValue(0), []table.Constraint{c(OperatorIsNotNull, "")}, true},
+		// booleans
+		{BoolValue(true), []table.Constraint{c(table.OperatorEquals, "true")}, true},
+		{BoolValue(false), []table.Constraint{c(OperatorNotEquals, "1")}, true},
+		// no pushdown
+		{IntValue(2), []table.Constraint{c(table.OperatorEquals, "abc")}, true},
+		{TextValue{V: "x"}, []table.Constraint{c(table.OperatorMatch, "y")}, true},
+		{TextValue{V: "x"}, []table.Constraint{c(table.OperatorMatch, "y"), c(table.OperatorEquals, "y")}, false},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			m, err := MatchConstraints(tc.val, table.ConstraintList{Constraints: tc.constraints})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expect, m, "%#v %v", tc.val, tc.constraints)
+		})
+	}
+}
+
+func TestMatchConstraintsError(t *testing.T) {
+	_, err := MatchConstraints(TextValue{V: "x"}, table.ConstraintList{Constraints: []table.Constraint{
+		{Operator: table.OperatorRegexp, Expression: "("},
+	}})
+	assert.Error(t, err)
+}
+
+func TestLowerBound(t *testing.T) {
+	c := func(op table.Operator, expr string) table.Constraint {
+		return table.Constraint{Operator: op, Expression: expr}
+	}
+
+	testCases := []struct {
+		constraints []table.Constraint
+		min         int64
+		ok          bool
+	}{
+		{nil, 0, false},
+		{[]table.Constraint{c(table.OperatorLessThan, "10")}, 0, false},
+		{[]table.Constraint{c(table.OperatorGreaterThan, "10")}, 11, true},
+		{[]table.Constraint{c(table.OperatorGreaterThanOrEquals, "10"), c(table.OperatorGreaterThan, "20")}, 21, true},
+		{[]table.Constraint{c(table.OperatorEquals, "30"), c(table.OperatorEquals, "20")}, 20, true},
+		{[]table.Constraint{c(table.OperatorEquals, "5"), c(table.OperatorGreaterThanOrEquals, "10")}, 10, true},
+		{[]table.Constraint{c(table.OperatorGreaterThan, "abc")}, 0, false},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			min, ok := LowerBound(table.QueryContext{Constraints: map[string]table.ConstraintList{
+				"x": {Constraints: tc.constraints},
+			}}, "x")
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.min, min)
+		})
+	}
+}
@@ -2,24 +2,69 @@ package extcommon
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"log"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/osquery/osquery-go"
 	"github.com/osquery/osquery-go/plugin/table"
 )
 
 type SchemaFunc = func() []table.ColumnDefinition
 type GenerateFunc = func(context.Context, table.QueryContext) ([]map[string]string, error)
-type Tables map[string]struct {
-	Schema   SchemaFunc
+
+// TableSpec describes a table provided by an extension.
+type TableSpec struct {
+	// Description documents the table's contents.
+	Description string
+	// Schema returns the table's columns. It is ignored if Columns is set.
+	Schema SchemaFunc
+	// Columns returns the table's columns along with their documentation.
+	Columns func() []ColumnInfo
+	// Generate returns the table's rows. It is ignored if Stream is set.
 	Generate GenerateFunc
+	// Stream generates the table's rows one at a time, which lets the framework stop it once the
+	// query's LIMIT or the table's limits are reached rather than holding every row in memory.
+	Stream StreamFunc
+	// PartialResults makes queries which time out or exceed the table's limits return the rows
+	// generated so far, rather than an error.
+	PartialResults bool
+	// Cache enables caching of the table's results, if set.
+	Cache *CacheSpec
+	// Timeout and Interval override the --timeout and --interval flags for this table if they're
+	// non-zero. A negative value disables the timeout or caching.
+	Timeout, Interval time.Duration
+	// MaxRows and MaxBytes override the --max-rows and --max-bytes flags for this table if
+	// they're non-zero. A negative value disables the limit.
+	MaxRows  int
+	MaxBytes int64
+	// MaxConcurrency limits the number of queries of the table which are generated at once, if
+	// positive. Tables with the same ConcurrencyGroup share the limit of the first one served,
+	// e.g. because they use a library which isn't safe for concurrent use.
+	MaxConcurrency   int
+	ConcurrencyGroup string
+	// Coalesce makes identical queries which are in flight at the same time share the rows of a
+	// single generation.
+	Coalesce bool
+	// Disabled stops the table from being served.
+	Disabled bool
 }
 
+type Tables map[string]TableSpec
+
+// abandonAfter is how long to wait for a generator to return after its context is done, before
+// giving up on it.
+var abandonAfter = time.Second
+
 func Main(name string, s SchemaFunc, g GenerateFunc) {
-	MainMulti(name, Tables{name: {s, g}})
+	MainMulti(name, Tables{name: {Schema: s, Generate: g}})
 }
 
 var Verbose *bool
@@ -39,37 +84,285 @@ func durationParser(out *time.Duration) func(string) error {
 	}
 }
 
+// isFlagSet reports whether a flag was passed on the command line or set by the config file.
+func isFlagSet(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return
+}
+
+// isBuiltinTable reports whether a table is one of the tables describing the extension itself which
+// MainMulti adds to every extension, rather than one provided by the extension.
+func isBuiltinTable(pluginName, name string) bool {
+	return name == ConfigTableName(pluginName) || name == StatsTableName(pluginName)
+}
+
+// addBuiltinTables replaces the placeholders of the built-in tables in t with the tables
+// themselves. The config table lists the served tables, so it can only be built once they're
+// known.
+func addBuiltinTables(pluginName string, t Tables, fs *flag.FlagSet, sources configSources) {
+	if _, ok := t[StatsTableName(pluginName)]; ok {
+		t[StatsTableName(pluginName)] = statsTable(pluginName, t)
+	}
+	if _, ok := t[ConfigTableName(pluginName)]; ok {
+		t[ConfigTableName(pluginName)] = configTable(fs, t, sources)
+	}
+}
+
+// WithBuiltinTables returns a copy of t along with the built-in tables MainMulti adds to every
+// extension, describing the configuration and query metrics of the named extension. It lets
+// programs calling Serve directly serve the same tables as MainMulti.
+func WithBuiltinTables(pluginName string, t Tables) Tables {
+	t = maps.Clone(t)
+	t[ConfigTableName(pluginName)] = TableSpec{}
+	t[StatsTableName(pluginName)] = TableSpec{}
+	addBuiltinTables(pluginName, t, flag.CommandLine, configSources{})
+	return t
+}
+
+// durationValue is a flag.Value for a duration, which may also be given as a number of seconds.
+type durationValue struct {
+	d *time.Duration
+}
+
+func (v durationValue) String() string {
+	if v.d == nil {
+		return ""
+	}
+	return v.d.String()
+}
+
+func (v durationValue) Set(s string) error {
+	return durationParser(v.d)(s)
+}
+
 func MainMulti(pluginName string, t Tables) {
 	socket := flag.String("socket", "/opt/fleet-orbit/orbit-osquery.em", "path to osquery extensions socket")
-	Verbose = flag.Bool("verbose", false, "enable extra debug logging")
-	flag.Func("timeout", "timeout for operations and queries", durationParser(&Timeout))
-	flag.Func("interval", "interval for operations and queries", durationParser(&Interval))
+	Verbose = flag.Bool("verbose", false, "enable extra debug logging; shorthand for --log-level=debug")
+	logLevel := flag.String("log-level", "info", "minimum level of log messages: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "format of log messages: text or json")
+	flag.Var(durationValue{&Timeout}, "timeout", "timeout for operations and queries")
+	flag.Var(durationValue{&Interval}, "interval", "interval for operations and queries")
+	flag.Var(durationValue{&PingInterval}, "ping-interval", "how often to check that osquery is still running")
+	flag.IntVar(&MaxRows, "max-rows", MaxRows, "maximum number of rows a query may return; 0 for no limit")
+	flag.Int64Var(&MaxBytes, "max-bytes", MaxBytes, "maximum approximate size in bytes of the rows a query may return; 0 for no limit")
+	flag.IntVar(&MaxRetries, "max-retries", MaxRetries, "number of consecutive failed attempts to connect to osquery before giving up; negative to retry forever")
+	enable := flag.String("enable", "", "comma-separated glob patterns of tables to serve; all tables are served if empty")
+	disable := flag.String("disable", "", "comma-separated glob patterns of tables not to serve")
+	query := flag.String("query", "", "query the named table without osquery, print the results and exit")
+	var where whereList
+	flag.Var(&where, "where", "constraint such as \"path=/etc/ssl/cert.pem\" to pass to --query; may be repeated")
+	columns := flag.String("columns", "", "comma-separated list of columns to request with --query")
+	format := flag.String("format", "table", "output format for --query: json, csv or table")
+	configPath := flag.String("config", "", "path to a YAML, JSON or TOML config file; defaults to $"+ConfigEnv)
+	statsFile := flag.String("stats-file", "", "path of a file to periodically write query metrics to in the Prometheus text format")
+	dumpSchemaFormat := flag.String("dump-schema", "", "print the schema of every served table and exit; one of spec, markdown or json")
+	statsInterval := 15 * time.Second
+	flag.Var(durationValue{&statsInterval}, "stats-interval", "how often to write query metrics to --stats-file")
 	flag.Parse()
 
-	if *socket == "" {
-		log.Fatal("please specify path to the osquery extensions socket")
+	// don't modify the caller's map when applying the config and adding the config table
+	t = maps.Clone(t)
+	sources := configSources{}
+	if *configPath == "" {
+		*configPath = os.Getenv(ConfigEnv)
+	}
+	if *configPath != "" {
+		c, err := loadConfig(*configPath)
+		if err != nil {
+			fatal("failed to load config", "error", err)
+		}
+		if sources, err = c.apply(flag.CommandLine, t); err != nil {
+			fatal("failed to load config", "error", err)
+		}
 	}
 
-	server, err := osquery.NewExtensionManagerServer(pluginName, *socket)
+	if *Verbose && !isFlagSet(flag.CommandLine, "log-level") {
+		*logLevel = "debug"
+	}
+	h, err := newLogHandler(os.Stderr, *logLevel, *logFormat)
 	if err != nil {
-		log.Fatalf("failed to connect to osquery socket: %v", err)
+		fatal("failed to set up logging", "error", err)
 	}
+	slog.SetDefault(slog.New(h).With("extension", pluginName))
 
-	for name, t := range t {
-		server.RegisterPlugin(table.NewPlugin(name, t.Schema(), wrapGenerate(name, t.Generate)))
+	// the built-in tables may be disabled too, so add placeholders for them before filtering
+	t[ConfigTableName(pluginName)] = TableSpec{}
+	t[StatsTableName(pluginName)] = TableSpec{}
+	t, err = filterTables(t, *enable, *disable)
+	if err != nil {
+		fatal("failed to select tables", "error", err)
 	}
-	log.Printf("running server for plugin %q", pluginName)
-	if err := server.Run(); err != nil {
-		log.Fatalf("failed running server: %v", err)
+	if !slices.ContainsFunc(slices.Collect(maps.Keys(t)), func(name string) bool { return !isBuiltinTable(pluginName, name) }) {
+		fatal("no tables enabled")
+	}
+	addBuiltinTables(pluginName, t, flag.CommandLine, sources)
+
+	if *dumpSchemaFormat != "" {
+		if err := dumpSchema(os.Stdout, t, *dumpSchemaFormat); err != nil {
+			fatal("failed to dump schema", "error", err)
+		}
+		return
+	}
+
+	if *query != "" {
+		spec, ok := t[*query]
+		if !ok {
+			fatal("no such table", "table", *query)
+		}
+		var cols []string
+		if *columns != "" {
+			cols = strings.Split(*columns, ",")
+		}
+		if err := runQuery(context.Background(), os.Stdout, *query, spec, where, cols, *format); err != nil {
+			if errors.As(err, new(loggedError)) {
+				os.Exit(1)
+			}
+			fatal("query failed", "table", *query, "error", err)
+		}
+		return
+	}
+
+	if *socket == "" {
+		fatal("please specify path to the osquery extensions socket")
 	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *statsFile != "" {
+		done := make(chan struct{})
+		go func() {
+			dumpStats(ctx, *statsFile, pluginName, statsInterval)
+			close(done)
+		}()
+		// write the final metrics before exiting
+		defer func() {
+			stop()
+			<-done
+		}()
+	}
+
+	slog.Info("running server", "socket", *socket, "tables", len(t))
+	if err := Serve(ctx, pluginName, *socket, t); err != nil {
+		fatal("failed running server", "error", err)
+	}
+}
+
+// columns returns the table's columns, documented if the table provides documentation.
+func (t TableSpec) columns() []ColumnInfo {
+	if t.Columns != nil {
+		return t.Columns()
+	}
+	if t.Schema == nil {
+		return nil
+	}
+	var out []ColumnInfo
+	for _, def := range t.Schema() {
+		out = append(out, ColumnInfo{ColumnDefinition: def})
+	}
+	return out
 }
 
-func wrapGenerate(name string, g GenerateFunc) GenerateFunc {
+// schema returns the osquery definitions of the table's columns.
+func (t TableSpec) schema() []table.ColumnDefinition {
+	if t.Columns == nil {
+		return t.Schema()
+	}
+	var out []table.ColumnDefinition
+	for _, c := range t.Columns() {
+		out = append(out, c.ColumnDefinition)
+	}
+	return out
+}
+
+// timeout returns the table's query timeout, which defaults to the --timeout flag.
+func (t TableSpec) timeout() time.Duration {
+	if t.Timeout != 0 {
+		return t.Timeout
+	}
+	return Timeout
+}
+
+// interval returns how long the table's results are cached, which defaults to the --interval
+// flag.
+func (t TableSpec) interval() time.Duration {
+	if t.Interval != 0 {
+		return t.Interval
+	}
+	return Interval
+}
+
+func wrapGenerate(name string, t TableSpec) GenerateFunc {
+	stats := statsFor(name)
+	logger := slog.With("table", name)
+	columns := t.columns()
+	g := limitConcurrency(name, t, t.generator())
+	if t.Coalesce {
+		g = coalesce(g)
+	}
+	if t.Cache != nil {
+		g = newResultCache(t.Cache, t.interval, stats).wrap(g)
+	}
+
 	return func(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
-		out, err := g(ctx, q)
+		if timeout := t.timeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		l := logger.With("constraints", constraintsValue(q.Constraints))
+		ctx = WithLogger(ctx, l)
+
+		start := time.Now()
+		var out []map[string]string
+		err := ValidateQuery(columns, q)
+		if err == nil {
+			out, err = generateWithContext(ctx, g, q)
+		}
+		d := time.Since(start)
+		if err != nil && (ctx.Err() != nil || errors.Is(err, ErrResultTooLarge)) && t.PartialResults {
+			l.Warn("query was interrupted, returning partial results",
+				"rows", len(out), "duration", d, "error", err)
+			stats.recordQuery(len(out), nil, true, d)
+			return out, nil
+		}
 		if err != nil {
-			log.Printf("error when querying plugin %q: %v", name, err)
+			l.Error("query failed", "duration", d, "error", err)
+		} else {
+			l.Debug("query succeeded", "rows", len(out), "duration", d)
 		}
+		stats.recordQuery(len(out), err, false, d)
 		return out, err
 	}
 }
+
+// generateWithContext runs a generator, returning the context's error if the generator doesn't
+// return soon after the context is done, e.g. because it's blocked on a hung filesystem.
+func generateWithContext(ctx context.Context, g GenerateFunc, q table.QueryContext) ([]map[string]string, error) {
+	type result struct {
+		rows []map[string]string
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		rows, err := g(ctx, q)
+		ch <- result{rows, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.rows, r.err
+	case <-ctx.Done():
+	}
+
+	select {
+	case r := <-ch:
+		return r.rows, r.err
+	case <-time.After(abandonAfter):
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,64 @@
+package extcommon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapGenerateTimeout(t *testing.T) {
+	defer func(d time.Duration) { Timeout = d }(Timeout)
+	Timeout = 10 * time.Millisecond
+
+	// slowGenerate emits a row every millisecond until its context is done
+	slowGenerate := func(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
+		for {
+			select {
+			case <-ctx.Done():
+				return out, ctx.Err()
+			case <-time.After(time.Millisecond):
+				out = append(out, map[string]string{"a": "b"})
+			}
+		}
+	}
+
+	_, err := wrapGenerate("test", TableSpec{Generate: slowGenerate})(context.Background(), table.QueryContext{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	out, err := wrapGenerate("test", TableSpec{Generate: slowGenerate, PartialResults: true})(
+		context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, out)
+}
+
+func TestWrapGenerateValidatesQuery(t *testing.T) {
+	called := false
+	g := wrapGenerate("test_validate", TableSpec{
+		Columns: Table[testRow]{
+			TextColumn("name", func(r testRow) string { return r.name }).WithOptions(ColumnRequired),
+		}.Columns,
+		Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+			called = true
+			return nil, nil
+		},
+	})
+	t.Cleanup(func() {
+		statsMu.Lock()
+		delete(stats, "test_validate")
+		statsMu.Unlock()
+	})
+
+	_, err := g(context.Background(), table.QueryContext{})
+	assert.ErrorIs(t, err, ErrMissingRequiredColumn)
+	assert.ErrorContains(t, err, `"name"`)
+	assert.False(t, called)
+
+	_, err = g(context.Background(), table.QueryContext{Constraints: map[string]table.ConstraintList{
+		"name": constraints(table.Constraint{Operator: table.OperatorEquals, Expression: "foo"}),
+	}})
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
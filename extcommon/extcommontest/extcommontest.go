@@ -0,0 +1,445 @@
+// Package extcommontest provides a fake osquery extension manager, so that tests can serve tables
+// with extcommon and query them over the same Thrift interface osqueryd uses.
+package extcommontest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/osquery/osquery-go/gen/osquery"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/osquery/osquery-go/transport"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+const (
+	// registerTimeout is how long to wait for an extension to register its tables.
+	registerTimeout = 10 * time.Second
+	// callTimeout is how long to wait for an extension to answer a call.
+	callTimeout = 30 * time.Second
+)
+
+// Manager is a fake osquery extension manager listening on a temporary socket.
+type Manager struct {
+	t      testing.TB
+	socket string
+	trans  *serverTransport
+	server *thrift.TSimpleServer
+
+	mu         sync.Mutex
+	nextUUID   osquery.ExtensionRouteUUID
+	extensions map[osquery.ExtensionRouteUUID]*extension
+	registered chan struct{}
+}
+
+// Query describes a query of a table, as osquery would pass it to the extension.
+type Query struct {
+	// Constraints are the constraints of the query's WHERE clause, keyed by column name.
+	Constraints map[string]table.ConstraintList
+	// Columns are the columns used by the query. If nil, the extension isn't told which columns
+	// are used, and generates all of them.
+	Columns []string
+}
+
+// extension is an extension registered with the manager.
+type extension struct {
+	info     *osquery.InternalExtensionInfo
+	registry osquery.ExtensionRegistry
+	socket   string
+
+	mu     sync.Mutex
+	trans  *thrift.TSocket
+	client *osquery.ExtensionClient
+}
+
+// serverTransport is a server socket which keeps track of the connections it accepts, so that
+// they can be closed when the manager stops. Otherwise, stopping the server would wait for the
+// extension to close its connections.
+type serverTransport struct {
+	*thrift.TServerSocket
+
+	mu      sync.Mutex
+	clients []thrift.TTransport
+}
+
+// NewManager starts a fake extension manager, which is stopped when the test finishes.
+func NewManager(t testing.TB) *Manager {
+	t.Helper()
+
+	// extensions listen on the manager's socket path with a suffix, and unix socket paths are
+	// short, so don't use t.TempDir, which includes the name of the test
+	dir, err := os.MkdirTemp("", "osquery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	m := &Manager{
+		t:          t,
+		socket:     path.Join(dir, "osquery.em"),
+		nextUUID:   1,
+		extensions: make(map[osquery.ExtensionRouteUUID]*extension),
+		registered: make(chan struct{}, 1),
+	}
+	m.start()
+	t.Cleanup(m.stop)
+	return m
+}
+
+// Socket returns the path of the socket extensions connect to.
+func (m *Manager) Socket() string {
+	return m.socket
+}
+
+// Restart simulates osqueryd restarting: the manager closes every connection, forgets the
+// registered extensions and starts listening again.
+func (m *Manager) Restart() {
+	m.t.Helper()
+	m.stop()
+	m.start()
+}
+
+// Serve serves the tables with extcommon.Serve until the test finishes, and waits for them to be
+// registered.
+func (m *Manager) Serve(name string, tables extcommon.Tables) {
+	m.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := extcommon.Serve(ctx, name, m.socket, tables); err != nil {
+			m.t.Errorf("failed to serve extension %q: %v", name, err)
+		}
+	}()
+	m.t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if err := m.WaitRegistered(name); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+// WaitRegistered waits for an extension with the given name to be registered and to listen for
+// calls.
+func (m *Manager) WaitRegistered(name string) error {
+	timeout := time.After(registerTimeout)
+	for {
+		// extensions only start listening once they're registered
+		e := m.find(func(e *extension) bool { return e.info.Name == name })
+		if e != nil {
+			if _, err := os.Stat(e.socket); err == nil {
+				return nil
+			}
+		}
+		select {
+		case <-m.registered:
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			return fmt.Errorf("extension %q was not registered within %s", name, registerTimeout)
+		}
+	}
+}
+
+// Tables returns the names of the tables registered by every extension.
+func (m *Manager) Tables() (out []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.extensions {
+		for name := range e.registry["table"] {
+			out = append(out, name)
+		}
+	}
+	return
+}
+
+// Columns returns the schema of a table, as registered by the extension serving it.
+func (m *Manager) Columns(name string) ([]table.ColumnDefinition, error) {
+	e := m.table(name)
+	if e == nil {
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+
+	var out []table.ColumnDefinition
+	for _, route := range e.registry["table"][name] {
+		if route["id"] == "column" {
+			out = append(out, table.ColumnDefinition{Name: route["name"], Type: table.ColumnType(route["type"])})
+		}
+	}
+	return out, nil
+}
+
+// Query queries a table through the extension serving it. If the extension reports that the
+// query failed, its message is returned as an error.
+func (m *Manager) Query(name string, q Query) ([]map[string]string, error) {
+	e := m.table(name)
+	if e == nil {
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+
+	ctxJSON, err := contextJSON(q)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.call(name, osquery.ExtensionPluginRequest{"action": "generate", "context": ctxJSON})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status.Code != 0 {
+		return nil, errors.New(resp.Status.Message)
+	}
+	return resp.Response, nil
+}
+
+// contextJSON serializes a query the way osquery passes it to extensions.
+func contextJSON(q Query) (string, error) {
+	type constraintJSON struct {
+		Op   table.Operator `json:"op"`
+		Expr string         `json:"expr"`
+	}
+	type constraintListJSON struct {
+		Name     string           `json:"name"`
+		Affinity string           `json:"affinity"`
+		List     []constraintJSON `json:"list"`
+	}
+	qc := struct {
+		Constraints []constraintListJSON `json:"constraints"`
+		ColsUsed    []string             `json:"colsUsed,omitempty"`
+	}{Constraints: []constraintListJSON{}, ColsUsed: q.Columns}
+
+	for col, cl := range q.Constraints {
+		list := constraintListJSON{Name: col, Affinity: string(cl.Affinity), List: []constraintJSON{}}
+		for _, c := range cl.Constraints {
+			list.List = append(list.List, constraintJSON{c.Operator, c.Expression})
+		}
+		qc.Constraints = append(qc.Constraints, list)
+	}
+
+	b, err := json.Marshal(qc)
+	return string(b), err
+}
+
+func (m *Manager) start() {
+	m.t.Helper()
+
+	sock, err := transport.OpenServer(m.socket, time.Second)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if err := sock.Listen(); err != nil {
+		m.t.Fatal(err)
+	}
+	m.trans = &serverTransport{TServerSocket: sock}
+	m.server = thrift.NewTSimpleServer2(osquery.NewExtensionManagerProcessor(handler{m}), m.trans)
+	go m.server.Serve()
+}
+
+func (m *Manager) stop() {
+	done := make(chan struct{})
+	go func() {
+		m.server.Stop()
+		close(done)
+	}()
+	// Stop waits for the connections to be closed, and the extension may open new ones until the
+	// listener is closed
+	for stopped := false; !stopped; {
+		m.trans.closeClients()
+		select {
+		case <-done:
+			stopped = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for uuid, e := range m.extensions {
+		e.close()
+		delete(m.extensions, uuid)
+	}
+}
+
+func (m *Manager) find(match func(*extension) bool) *extension {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.extensions {
+		if match(e) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (m *Manager) table(name string) *extension {
+	return m.find(func(e *extension) bool {
+		_, ok := e.registry["table"][name]
+		return ok
+	})
+}
+
+// call calls a plugin of the extension, connecting to it first if necessary.
+func (e *extension) call(name string, req osquery.ExtensionPluginRequest) (*osquery.ExtensionResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client == nil {
+		addr, err := net.ResolveUnixAddr("unix", e.socket)
+		if err != nil {
+			return nil, err
+		}
+		conf := &thrift.TConfiguration{ConnectTimeout: time.Second, SocketTimeout: callTimeout}
+		trans := thrift.NewTSocketFromAddrConf(addr, conf)
+		if err := trans.Open(); err != nil {
+			return nil, err
+		}
+		e.trans = trans
+		e.client = osquery.NewExtensionClientFactory(trans, thrift.NewTBinaryProtocolFactoryConf(conf))
+	}
+	return e.client.Call(context.Background(), "table", name, req)
+}
+
+func (e *extension) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.trans != nil {
+		e.trans.Close()
+		e.trans, e.client = nil, nil
+	}
+}
+
+func (s *serverTransport) Accept() (thrift.TTransport, error) {
+	c, err := s.TServerSocket.Accept()
+	if c != nil {
+		s.mu.Lock()
+		s.clients = append(s.clients, c)
+		s.mu.Unlock()
+	}
+	return c, err
+}
+
+func (s *serverTransport) closeClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.clients {
+		c.Close()
+	}
+	s.clients = nil
+}
+
+// handler implements the Thrift interface of the extension manager.
+type handler struct {
+	m *Manager
+}
+
+func status(code int32, msg string) *osquery.ExtensionStatus {
+	return &osquery.ExtensionStatus{Code: code, Message: msg}
+}
+
+// Ping implements osquery.ExtensionManager
+func (handler) Ping(context.Context) (*osquery.ExtensionStatus, error) {
+	return status(0, "OK"), nil
+}
+
+// Call implements osquery.ExtensionManager
+func (h handler) Call(_ context.Context, registry, item string, req osquery.ExtensionPluginRequest) (*osquery.ExtensionResponse, error) {
+	e := h.m.table(item)
+	if registry != "table" || e == nil {
+		return &osquery.ExtensionResponse{Status: status(1, "no such plugin")}, nil
+	}
+	return e.call(item, req)
+}
+
+// Shutdown implements osquery.ExtensionManager
+func (handler) Shutdown(context.Context) error {
+	return nil
+}
+
+// Extensions implements osquery.ExtensionManager
+func (h handler) Extensions(context.Context) (osquery.InternalExtensionList, error) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+
+	out := make(osquery.InternalExtensionList, len(h.m.extensions))
+	for uuid, e := range h.m.extensions {
+		out[uuid] = e.info
+	}
+	return out, nil
+}
+
+// Options implements osquery.ExtensionManager
+func (handler) Options(context.Context) (osquery.InternalOptionList, error) {
+	return osquery.InternalOptionList{}, nil
+}
+
+// RegisterExtension implements osquery.ExtensionManager. Like osquery, it refuses to register a
+// table which another extension has already registered.
+func (h handler) RegisterExtension(_ context.Context, info *osquery.InternalExtensionInfo, registry osquery.ExtensionRegistry) (*osquery.ExtensionStatus, error) {
+	m := h.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.extensions {
+		for name := range registry["table"] {
+			if _, ok := e.registry["table"][name]; ok {
+				return status(1, "Duplicate registry item exists: "+name), nil
+			}
+		}
+	}
+
+	uuid := m.nextUUID
+	m.nextUUID++
+	m.extensions[uuid] = &extension{
+		info:     info,
+		registry: registry,
+		socket:   fmt.Sprintf("%s.%d", m.socket, uuid),
+	}
+
+	select {
+	case m.registered <- struct{}{}:
+	default:
+	}
+
+	s := status(0, "OK")
+	s.UUID = uuid
+	return s, nil
+}
+
+// DeregisterExtension implements osquery.ExtensionManager
+func (h handler) DeregisterExtension(_ context.Context, uuid osquery.ExtensionRouteUUID) (*osquery.ExtensionStatus, error) {
+	m := h.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.extensions[uuid]
+	if !ok {
+		return status(1, "No extension UUID registered"), nil
+	}
+	e.close()
+	delete(m.extensions, uuid)
+	return status(0, "OK"), nil
+}
+
+// Query implements osquery.ExtensionManager
+func (handler) Query(context.Context, string) (*osquery.ExtensionResponse, error) {
+	return &osquery.ExtensionResponse{Status: status(1, "not supported")}, nil
+}
+
+// GetQueryColumns implements osquery.ExtensionManager
+func (handler) GetQueryColumns(context.Context, string) (*osquery.ExtensionResponse, error) {
+	return &osquery.ExtensionResponse{Status: status(1, "not supported")}, nil
+}
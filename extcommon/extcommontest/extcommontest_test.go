@@ -0,0 +1,153 @@
+package extcommontest
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+type testRow struct {
+	name string
+	size int64
+}
+
+var testTable = extcommon.Table[testRow]{
+	extcommon.TextColumn("name", func(r testRow) string { return r.name }),
+	extcommon.BigIntColumn("size", func(r testRow) int64 { return r.size }),
+}
+
+func testTables() extcommon.Tables {
+	return extcommon.Tables{
+		"test_rows": {
+			Schema: testTable.Schema,
+			Generate: func(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
+				tbl := testTable.ForQuery(ctx, q)
+				for _, r := range []testRow{{"a", 1}, {"b", 2}, {"c", 3}} {
+					if row, ok, err := tbl.Row(q, r); err != nil {
+						return nil, err
+					} else if ok {
+						out = append(out, row)
+					}
+				}
+				return
+			},
+		},
+		"test_error": {
+			Schema: testTable.Schema,
+			Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+				return nil, errors.New("boom")
+			},
+		},
+	}
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager(t)
+	m.Serve("test", testTables())
+
+	assert.ElementsMatch(t, []string{"test_rows", "test_error"}, m.Tables())
+
+	cols, err := m.Columns("test_rows")
+	assert.NoError(t, err)
+	assert.Equal(t, testTable.Schema(), cols)
+
+	rows, err := m.Query("test_rows", Query{
+		Constraints: map[string]table.ConstraintList{
+			"size": {Affinity: table.ColumnTypeBigInt, Constraints: []table.Constraint{
+				{Operator: table.OperatorGreaterThan, Expression: "1"},
+			}},
+		},
+		Columns: []string{"name"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"name": "b", "size": "2"}, {"name": "c", "size": "3"}}, rows)
+
+	_, err = m.Query("test_error", Query{})
+	assert.ErrorContains(t, err, "boom")
+
+	_, err = m.Query("missing", Query{})
+	assert.Error(t, err)
+}
+
+func TestManagerRestart(t *testing.T) {
+	// restore the ping interval once the extension has stopped, which happens in a cleanup function
+	pingInterval := extcommon.PingInterval
+	t.Cleanup(func() { extcommon.PingInterval = pingInterval })
+	extcommon.PingInterval = 50 * time.Millisecond
+
+	m := NewManager(t)
+	m.Serve("test", testTables())
+
+	m.Restart()
+	assert.Empty(t, m.Tables())
+	assert.NoError(t, m.WaitRegistered("test"), "extension should register again after a restart")
+
+	rows, err := m.Query("test_rows", Query{Columns: []string{"name"}})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+}
+
+func TestManagerMultipleExtensions(t *testing.T) {
+	m := NewManager(t)
+	m.Serve("first", extcommon.WithBuiltinTables("first", extcommon.Tables{"first_rows": testTables()["test_rows"]}))
+	m.Serve("second", extcommon.WithBuiltinTables("second", extcommon.Tables{"second_rows": testTables()["test_rows"]}))
+
+	assert.ElementsMatch(t, []string{
+		"first_rows", "first_extension_config", "first_extension_stats",
+		"second_rows", "second_extension_config", "second_extension_stats",
+	}, m.Tables())
+
+	for _, name := range []string{"first", "second"} {
+		rows, err := m.Query(name+"_rows", Query{})
+		assert.NoError(t, err)
+		assert.Len(t, rows, 3)
+
+		// each extension only reports the metrics of its own tables
+		rows, err = m.Query(extcommon.StatsTableName(name), Query{Columns: []string{"extension", "name", "calls"}})
+		assert.NoError(t, err)
+		assert.Contains(t, rows, map[string]string{"extension": name, "name": name + "_rows", "calls": "1"})
+		for _, row := range rows {
+			assert.Contains(t, []string{name + "_rows", extcommon.ConfigTableName(name), extcommon.StatsTableName(name)}, row["name"])
+		}
+
+		rows, err = m.Query(extcommon.ConfigTableName(name), Query{})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, rows)
+	}
+}
+
+func TestManagerRestartKeepsCache(t *testing.T) {
+	pingInterval := extcommon.PingInterval
+	t.Cleanup(func() { extcommon.PingInterval = pingInterval })
+	extcommon.PingInterval = 50 * time.Millisecond
+
+	var calls atomic.Int32
+	m := NewManager(t)
+	m.Serve("test", extcommon.Tables{
+		"test_cached": {
+			Schema:   testTable.Schema,
+			Cache:    &extcommon.CacheSpec{},
+			Interval: time.Minute,
+			Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+				calls.Add(1)
+				return []map[string]string{{"name": "a", "size": "1"}}, nil
+			},
+		},
+	})
+
+	_, err := m.Query("test_cached", Query{})
+	assert.NoError(t, err)
+
+	m.Restart()
+	assert.NoError(t, m.WaitRegistered("test"))
+	rows, err := m.Query("test_cached", Query{})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, int32(1), calls.Load(), "results should still be cached after reconnecting")
+}
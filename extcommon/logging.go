@@ -0,0 +1,94 @@
+package extcommon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+var operatorNames = map[table.Operator]string{
+	table.OperatorEquals:              "=",
+	table.OperatorGreaterThan:         ">",
+	table.OperatorLessThanOrEquals:    "<=",
+	table.OperatorLessThan:            "<",
+	table.OperatorGreaterThanOrEquals: ">=",
+	table.OperatorMatch:               "MATCH",
+	table.OperatorLike:                "LIKE",
+	table.OperatorGlob:                "GLOB",
+	table.OperatorRegexp:              "REGEXP",
+	table.OperatorUnique:              "UNIQUE",
+	OperatorNotEquals:                 "!=",
+	OperatorIsNot:                     "IS NOT",
+	OperatorIsNotNull:                 "IS NOT NULL",
+	OperatorIsNull:                    "IS NULL",
+	OperatorIs:                        "IS",
+	OperatorLimit:                     "LIMIT",
+	OperatorOffset:                    "OFFSET",
+}
+
+// newLogHandler returns a handler writing log records of at least the given level to w, as text
+// or, if format is "json", as JSON objects.
+func newLogHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unsupported log format %q", format)
+}
+
+// WithLogger returns a context carrying a logger, which generators retrieve with Logger.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// Logger returns the logger carried by ctx, which has attributes identifying the table and query
+// being generated, or the default logger if there's none.
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// constraintsValue logs the constraints of a query in SQL syntax.
+type constraintsValue map[string]table.ConstraintList
+
+func (c constraintsValue) LogValue() slog.Value {
+	columns := make([]string, 0, len(c))
+	for col := range c {
+		columns = append(columns, col)
+	}
+	slices.Sort(columns)
+
+	var terms []string
+	for _, col := range columns {
+		for _, cons := range c[col].Constraints {
+			op, ok := operatorNames[cons.Operator]
+			if !ok {
+				op = fmt.Sprintf("op%d", cons.Operator)
+			}
+			terms = append(terms, fmt.Sprintf("%s %s %q", col, op, cons.Expression))
+		}
+	}
+	return slog.StringValue(strings.Join(terms, " AND "))
+}
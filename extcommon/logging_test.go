@@ -0,0 +1,64 @@
+package extcommon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := newLogHandler(&buf, "warn", "json")
+	assert.NoError(t, err)
+
+	l := slog.New(h)
+	l.Info("dropped")
+	l.Warn("kept", "table", "a")
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "kept", record["msg"])
+	assert.Equal(t, "a", record["table"])
+
+	_, err = newLogHandler(&buf, "loud", "text")
+	assert.Error(t, err)
+	_, err = newLogHandler(&buf, "info", "xml")
+	assert.Error(t, err)
+}
+
+func TestWrapGenerateLogger(t *testing.T) {
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+
+	var buf bytes.Buffer
+	h, _ := newLogHandler(&buf, "debug", "json")
+	slog.SetDefault(slog.New(h))
+
+	g := wrapGenerate("test_logger", TableSpec{
+		Generate: func(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
+			Logger(ctx).Info("generating")
+			return nil, errors.New("boom")
+		},
+	})
+	_, err := g(context.Background(), table.QueryContext{Constraints: map[string]table.ConstraintList{
+		"path": constraints(
+			table.Constraint{Operator: table.OperatorEquals, Expression: "/a"},
+			table.Constraint{Operator: table.OperatorLike, Expression: "%b"},
+		),
+	}})
+	assert.Error(t, err)
+
+	dec := json.NewDecoder(&buf)
+	for _, msg := range []string{"generating", "query failed"} {
+		var record map[string]any
+		assert.NoError(t, dec.Decode(&record))
+		assert.Equal(t, msg, record["msg"])
+		assert.Equal(t, "test_logger", record["table"])
+		assert.Equal(t, `path = "/a" AND path LIKE "%b"`, record["constraints"])
+	}
+}
@@ -0,0 +1,99 @@
+package extcommon
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/osquery/osquery-go/gen/osquery"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+type contextKey int
+
+const (
+	usedColumnsKey contextKey = iota
+	loggerKey
+)
+
+// tablePlugin is a table plugin which passes the columns used by a query to its generate
+// function. osquery sends these with the query context, but table.Plugin discards them. It also
+// registers the options of the columns, which table.Plugin always sets to the default.
+type tablePlugin struct {
+	*table.Plugin
+	columns []ColumnInfo
+}
+
+func newTablePlugin(name string, columns []ColumnInfo, gen GenerateFunc) *tablePlugin {
+	defs := make([]table.ColumnDefinition, len(columns))
+	for i, c := range columns {
+		defs[i] = c.ColumnDefinition
+	}
+	return &tablePlugin{table.NewPlugin(name, defs, gen), columns}
+}
+
+// Routes implements osquery.OsqueryPlugin
+func (p *tablePlugin) Routes() osquery.ExtensionPluginResponse {
+	routes := osquery.ExtensionPluginResponse{}
+	for _, c := range p.columns {
+		routes = append(routes, map[string]string{
+			"id":   "column",
+			"name": c.Name,
+			"type": string(c.Type),
+			"op":   strconv.Itoa(int(c.Options)),
+		})
+	}
+	return routes
+}
+
+// Call implements osquery.OsqueryPlugin
+func (p *tablePlugin) Call(ctx context.Context, req osquery.ExtensionPluginRequest) osquery.ExtensionResponse {
+	switch req["action"] {
+	case "generate":
+		var qc struct {
+			ColsUsed *[]string `json:"colsUsed"`
+		}
+		if err := json.Unmarshal([]byte(req["context"]), &qc); err == nil && qc.ColsUsed != nil {
+			ctx = WithUsedColumns(ctx, *qc.ColsUsed)
+		}
+	case "columns":
+		return osquery.ExtensionResponse{
+			Status:   &osquery.ExtensionStatus{Code: 0, Message: "OK"},
+			Response: p.Routes(),
+		}
+	}
+	return p.Plugin.Call(ctx, req)
+}
+
+// WithUsedColumns returns a context which records the columns used by a query.
+func WithUsedColumns(ctx context.Context, columns []string) context.Context {
+	used := make(map[string]struct{}, len(columns))
+	for _, c := range columns {
+		used[c] = struct{}{}
+	}
+	return context.WithValue(ctx, usedColumnsKey, used)
+}
+
+// UsedColumns returns the columns used by the query. ok is false if osquery didn't say which
+// columns are used, in which case all of them must be generated.
+func UsedColumns(ctx context.Context) (columns []string, ok bool) {
+	used, ok := ctx.Value(usedColumnsKey).(map[string]struct{})
+	for c := range used {
+		columns = append(columns, c)
+	}
+	return columns, ok
+}
+
+// ColumnNeeded reports whether a generator needs to compute the named column, either because
+// the query uses it or because it has constraints that must be evaluated.
+func ColumnNeeded(ctx context.Context, q table.QueryContext, name string) bool {
+	if _, ok := q.Constraints[name]; ok {
+		return true
+	}
+	used, ok := ctx.Value(usedColumnsKey).(map[string]struct{})
+	if !ok {
+		return true
+	}
+	_, ok = used[name]
+	return ok
+}
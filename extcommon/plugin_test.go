@@ -0,0 +1,80 @@
+package extcommon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/osquery/osquery-go/gen/osquery"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTablePluginUsedColumns(t *testing.T) {
+	type testCase struct {
+		name, context string
+		expectOk      bool
+		expectRow     map[string]string
+	}
+
+	var testCases = []*testCase{
+		{
+			name:      "no colsUsed",
+			context:   `{"constraints":[]}`,
+			expectOk:  false,
+			expectRow: map[string]string{"name": "foo", "size": "1024", "enabled": "1"},
+		},
+		{
+			name:      "colsUsed",
+			context:   `{"constraints":[],"colsUsed":["size"]}`,
+			expectOk:  true,
+			expectRow: map[string]string{"size": "1024"},
+		},
+		{
+			name: "colsUsed with constraint",
+			context: `{"constraints":[{"name":"name","affinity":"TEXT","list":[{"op":2,"expr":"foo"}]}],` +
+				`"colsUsed":["size"]}`,
+			expectOk:  true,
+			expectRow: map[string]string{"name": "foo", "size": "1024"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ok bool
+			gen := func(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
+				_, ok = UsedColumns(ctx)
+				row, _, err := testTable.ForQuery(ctx, q).Row(q, testRow{"foo", 1024, true})
+				return []map[string]string{row}, err
+			}
+
+			p := newTablePlugin("test", testTable.Columns(), gen)
+			resp := p.Call(context.Background(), osquery.ExtensionPluginRequest{
+				"action":  "generate",
+				"context": tc.context,
+			})
+
+			assert.Equal(t, int32(0), resp.Status.Code, resp.Status.Message)
+			assert.Equal(t, tc.expectOk, ok)
+			assert.Equal(t, osquery.ExtensionPluginResponse{tc.expectRow}, resp.Response)
+		})
+	}
+}
+
+func TestTablePluginRoutes(t *testing.T) {
+	p := newTablePlugin("test", Table[testRow]{
+		TextColumn("name", func(r testRow) string { return r.name }).WithOptions(ColumnRequired | ColumnIndex),
+		BigIntColumn("size", func(r testRow) int64 { return r.size }),
+		BoolColumn("enabled", func(r testRow) bool { return r.enabled }).WithOptions(ColumnHidden),
+	}.Columns(), nil)
+
+	expected := osquery.ExtensionPluginResponse{
+		{"id": "column", "name": "name", "type": "TEXT", "op": "3"},
+		{"id": "column", "name": "size", "type": "BIGINT", "op": "0"},
+		{"id": "column", "name": "enabled", "type": "INTEGER", "op": "16"},
+	}
+	assert.Equal(t, expected, p.Routes())
+
+	resp := p.Call(context.Background(), osquery.ExtensionPluginRequest{"action": "columns"})
+	assert.Equal(t, int32(0), resp.Status.Code)
+	assert.Equal(t, expected, resp.Response)
+}
@@ -0,0 +1,170 @@
+package extcommon
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+var whereRegexp = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*(!=|<>|>=|<=|==|=|>|<|(?i:like|glob|regexp)\s)\s*(.*)$`)
+
+var whereOperators = map[string]table.Operator{
+	"=":      table.OperatorEquals,
+	"==":     table.OperatorEquals,
+	"!=":     OperatorNotEquals,
+	"<>":     OperatorNotEquals,
+	">":      table.OperatorGreaterThan,
+	">=":     table.OperatorGreaterThanOrEquals,
+	"<":      table.OperatorLessThan,
+	"<=":     table.OperatorLessThanOrEquals,
+	"like":   table.OperatorLike,
+	"glob":   table.OperatorGlob,
+	"regexp": table.OperatorRegexp,
+}
+
+// queryFormats are the output formats of runQuery.
+var queryFormats = []string{"json", "csv", "table"}
+
+// whereList is a flag.Value collecting constraints such as "path=/etc/ssl/cert.pem" or
+// "name LIKE 'lib%'".
+type whereList []string
+
+func (w *whereList) String() string {
+	return strings.Join(*w, " AND ")
+}
+
+func (w *whereList) Set(v string) error {
+	*w = append(*w, v)
+	return nil
+}
+
+// queryContext builds the query context osquery would pass for the given constraints.
+func queryContext(schema []table.ColumnDefinition, where []string) (table.QueryContext, error) {
+	q := table.QueryContext{Constraints: make(map[string]table.ConstraintList)}
+	for _, w := range where {
+		m := whereRegexp.FindStringSubmatch(w)
+		if m == nil {
+			return q, fmt.Errorf("invalid constraint %q: expected column, operator and value", w)
+		}
+		col, op, expr := m[1], whereOperators[strings.ToLower(strings.TrimSpace(m[2]))], m[3]
+		if len(expr) >= 2 && expr[0] == '\'' && expr[len(expr)-1] == '\'' {
+			expr = expr[1 : len(expr)-1]
+		}
+
+		affinity := table.ColumnType("")
+		for _, c := range schema {
+			if c.Name == col {
+				affinity = c.Type
+			}
+		}
+		if affinity == "" {
+			return q, fmt.Errorf("invalid constraint %q: no such column %q", w, col)
+		}
+
+		cl := q.Constraints[col]
+		cl.Affinity = affinity
+		cl.Constraints = append(cl.Constraints, table.Constraint{Operator: op, Expression: expr})
+		q.Constraints[col] = cl
+	}
+	return q, nil
+}
+
+// runQuery queries a table without osquery, printing the resulting rows to w in the given
+// format, which is one of "json", "csv" or "table". If columns is not empty, only those columns
+// are requested from the table; otherwise, every column which isn't hidden is, like "SELECT *".
+func runQuery(ctx context.Context, w io.Writer, name string, t TableSpec, where, columns []string, format string) error {
+	// check the arguments before generating the table, which may be expensive
+	if !slices.Contains(queryFormats, format) {
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+	schema := t.schema()
+	q, err := queryContext(schema, where)
+	if err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		for _, c := range t.columns() {
+			if c.Options&ColumnHidden == 0 {
+				columns = append(columns, c.Name)
+			}
+		}
+	}
+	for _, col := range columns {
+		if !slices.ContainsFunc(schema, func(c table.ColumnDefinition) bool { return c.Name == col }) {
+			return fmt.Errorf("no such column %q", col)
+		}
+	}
+	ctx = WithUsedColumns(ctx, columns)
+
+	rows, err := wrapGenerate(name, t)(ctx, q)
+	if err != nil {
+		return loggedError{err}
+	}
+
+	switch format {
+	case "json":
+		// only print the requested columns, not those which were computed to evaluate constraints
+		out := make([]map[string]string, 0, len(rows))
+		for _, row := range rows {
+			r := make(map[string]string, len(columns))
+			for _, c := range columns {
+				r[c] = row[c]
+			}
+			out = append(out, r)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write(columns)
+		for _, row := range rows {
+			_ = cw.Write(rowValues(row, columns))
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+		for _, row := range rows {
+			values := rowValues(row, columns)
+			for i, v := range values {
+				values[i] = tableEscaper.Replace(v)
+			}
+			fmt.Fprintln(tw, strings.Join(values, "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unsupported output format %q", format)
+}
+
+// tableEscaper escapes the characters of values which would break the alignment of the table
+// output format.
+var tableEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// loggedError is an error which was already logged, e.g. by the generator returned by
+// wrapGenerate, so that it isn't logged again.
+type loggedError struct {
+	error
+}
+
+func (e loggedError) Unwrap() error {
+	return e.error
+}
+
+func rowValues(row map[string]string, columns []string) []string {
+	out := make([]string, len(columns))
+	for i, c := range columns {
+		out[i] = row[c]
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package extcommon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryContext(t *testing.T) {
+	q, err := queryContext(testTable.Schema(), []string{
+		"name=foo",
+		"name LIKE 'f%'",
+		"size >= 10",
+		"size<2048",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]table.ConstraintList{
+		"name": {
+			Affinity: table.ColumnTypeText,
+			Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: "foo"},
+				{Operator: table.OperatorLike, Expression: "f%"},
+			},
+		},
+		"size": {
+			Affinity: table.ColumnTypeBigInt,
+			Constraints: []table.Constraint{
+				{Operator: table.OperatorGreaterThanOrEquals, Expression: "10"},
+				{Operator: table.OperatorLessThan, Expression: "2048"},
+			},
+		},
+	}, q.Constraints)
+
+	_, err = queryContext(testTable.Schema(), []string{"nonexistent=1"})
+	assert.Error(t, err)
+
+	_, err = queryContext(testTable.Schema(), []string{"name"})
+	assert.Error(t, err)
+}
+
+func TestRunQuery(t *testing.T) {
+	spec := TableSpec{
+		Schema: testTable.Schema,
+		Generate: func(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
+			tbl := testTable.ForQuery(ctx, q)
+			for _, v := range []testRow{{"foo", 1, true}, {"bar", 2, false}} {
+				if row, ok, err := tbl.Row(q, v); ok && err == nil {
+					out = append(out, row)
+				}
+			}
+			return
+		},
+	}
+
+	type testCase struct {
+		format  string
+		where   []string
+		columns []string
+		expect  string
+	}
+
+	var testCases = []*testCase{
+		{"csv", nil, nil, "name,size,enabled\nfoo,1,1\nbar,2,0\n"},
+		{"csv", []string{"size>1"}, []string{"name"}, "name\nbar\n"},
+		{"json", []string{"name=foo"}, []string{"size"}, "[\n  {\n    \"size\": \"1\"\n  }\n]\n"},
+		{"json", []string{"name=baz"}, nil, "[]\n"},
+		{"table", nil, []string{"name", "enabled"}, "name  enabled\nfoo   1\nbar   0\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := runQuery(context.Background(), &buf, "test", spec, tc.where, tc.columns, tc.format)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expect, buf.String())
+		})
+	}
+}
+
+func TestRunQueryInvalid(t *testing.T) {
+	generated := false
+	spec := TableSpec{
+		Schema: testTable.Schema,
+		Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+			generated = true
+			return nil, nil
+		},
+	}
+
+	var buf bytes.Buffer
+	err := runQuery(context.Background(), &buf, "test", spec, nil, nil, "jsn")
+	assert.ErrorContains(t, err, `unsupported output format "jsn"`)
+	err = runQuery(context.Background(), &buf, "test", spec, nil, []string{"name", "nme"}, "json")
+	assert.ErrorContains(t, err, `no such column "nme"`)
+	assert.False(t, generated, "the table shouldn't be generated for invalid arguments")
+	assert.Empty(t, buf.String())
+}
+
+func TestRunQueryEscape(t *testing.T) {
+	spec := TableSpec{
+		Schema: testTable.Schema,
+		Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+			return []map[string]string{{"name": "a\nb\tc\\d", "size": "1"}}, nil
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, runQuery(context.Background(), &buf, "test", spec, nil, []string{"name", "size"}, "table"))
+	assert.Equal(t, "name        size\na\\nb\\tc\\\\d  1\n", buf.String())
+
+	// errors of the generator are logged by wrapGenerate, so they aren't logged again
+	spec.Generate = func(context.Context, table.QueryContext) ([]map[string]string, error) {
+		return nil, errors.New("boom")
+	}
+	err := runQuery(context.Background(), &buf, "test", spec, nil, nil, "table")
+	assert.ErrorAs(t, err, new(loggedError))
+	assert.ErrorContains(t, err, "boom")
+}
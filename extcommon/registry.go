@@ -0,0 +1,84 @@
+package extcommon
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	registry   = Tables{}
+	registryMu sync.Mutex
+)
+
+// RegisterTable registers a table to be served by MainRegistered. Packages providing tables
+// should call it from their init function, so that a binary serves every table of the packages
+// it imports.
+func RegisterTable(name string, t TableSpec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		fatal("table registered twice", "table", name)
+	}
+	registry[name] = t
+}
+
+// RegisteredTables returns every table registered with RegisterTable.
+func RegisteredTables() Tables {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	out := make(Tables, len(registry))
+	for name, t := range registry {
+		out[name] = t
+	}
+	return out
+}
+
+// MainRegistered runs an extension serving every table registered with RegisterTable.
+func MainRegistered(pluginName string) {
+	MainMulti(pluginName, RegisteredTables())
+}
+
+// filterTables returns the tables whose names match any of the comma-separated glob patterns in
+// enable, or all tables if enable is empty, excluding those which match any pattern in disable.
+func filterTables(t Tables, enable, disable string) (Tables, error) {
+	out := make(Tables)
+	for name, spec := range t {
+		if enable != "" {
+			m, err := matchAnyGlob(enable, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for --enable: %v", err)
+			}
+			if !m {
+				continue
+			}
+		}
+
+		m, err := matchAnyGlob(disable, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for --disable: %v", err)
+		}
+		if !m {
+			out[name] = spec
+		}
+	}
+	return out, nil
+}
+
+func matchAnyGlob(patterns, s string) (bool, error) {
+	for _, p := range strings.Split(patterns, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		g, err := CompileGlob(p)
+		if err != nil {
+			return false, err
+		}
+		if g.Match(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,44 @@
+package extcommon
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterTables(t *testing.T) {
+	type testCase struct {
+		enable, disable string
+		expect          []string
+	}
+
+	tables := Tables{
+		"pacman_packages":   {},
+		"pacman_files":      {},
+		"flatpak_packages":  {},
+		"x509_certificates": {},
+	}
+
+	var testCases = []*testCase{
+		{"", "", []string{"flatpak_packages", "pacman_files", "pacman_packages", "x509_certificates"}},
+		{"pacman_*", "", []string{"pacman_files", "pacman_packages"}},
+		{"pacman_*", "pacman_files", []string{"pacman_packages"}},
+		{"", "*_packages", []string{"pacman_files", "x509_certificates"}},
+		{"x509_certificates, flatpak_packages", "", []string{"flatpak_packages", "x509_certificates"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.enable+"/"+tc.disable, func(t *testing.T) {
+			out, err := filterTables(tables, tc.enable, tc.disable)
+			assert.NoError(t, err)
+
+			var names []string
+			for name := range out {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			assert.Equal(t, tc.expect, names)
+		})
+	}
+}
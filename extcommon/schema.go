@@ -0,0 +1,121 @@
+package extcommon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// schemaFormats are the formats supported by --dump-schema.
+var schemaFormats = map[string]func(io.Writer, []string, Tables) error{
+	"spec":     writeSchemaSpec,
+	"markdown": writeSchemaMarkdown,
+	"json":     writeSchemaJSON,
+}
+
+// dumpSchema documents every table in t in the given format, which is one of "spec" for
+// osquery's .table spec files, "markdown" or "json".
+func dumpSchema(w io.Writer, t Tables, format string) error {
+	write, ok := schemaFormats[format]
+	if !ok {
+		return fmt.Errorf("unsupported schema format %q", format)
+	}
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return write(w, names, t)
+}
+
+// writeSchemaSpec writes the tables in the format of osquery's .table spec files, separated by
+// blank lines.
+func writeSchemaSpec(w io.Writer, names []string, t Tables) error {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "table_name(%s)\n", strconv.Quote(name))
+		fmt.Fprintf(&b, "description(%s)\n", strconv.Quote(t[name].Description))
+		b.WriteString("schema([\n")
+		for _, c := range t[name].columns() {
+			fmt.Fprintf(&b, "    Column(%s, %s, %s", strconv.Quote(c.Name), c.Type, strconv.Quote(c.Description))
+			for _, o := range c.Options.Names() {
+				fmt.Fprintf(&b, ", %s=True", o)
+			}
+			b.WriteString("),\n")
+		}
+		b.WriteString("])\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSchemaMarkdown writes a section documenting each table, with a table of its columns.
+func writeSchemaMarkdown(w io.Writer, names []string, t Tables) error {
+	cell := strings.NewReplacer("|", `\|`, "\n", " ").Replace
+
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "#### `%s`\n\n", name)
+		if d := t[name].Description; d != "" {
+			fmt.Fprintf(&b, "%s\n\n", d)
+		}
+		b.WriteString("| Column | Type | Description |\n")
+		b.WriteString("| --- | --- | --- |\n")
+		for _, c := range t[name].columns() {
+			typ := string(c.Type)
+			if opts := c.Options.Names(); len(opts) > 0 {
+				typ += " (" + strings.Join(opts, ", ") + ")"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s |\n", c.Name, typ, cell(c.Description))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type schemaTable struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Columns     []schemaColumn `json:"columns"`
+}
+
+type schemaColumn struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Index       bool   `json:"index"`
+	Hidden      bool   `json:"hidden"`
+}
+
+// writeSchemaJSON writes the tables as a JSON array in the format of the osquery schema used by
+// fleet to validate queries.
+func writeSchemaJSON(w io.Writer, names []string, t Tables) error {
+	out := make([]schemaTable, 0, len(names))
+	for _, name := range names {
+		st := schemaTable{Name: name, Description: t[name].Description, Columns: []schemaColumn{}}
+		for _, c := range t[name].columns() {
+			st.Columns = append(st.Columns, schemaColumn{
+				Name:        c.Name,
+				Description: c.Description,
+				Type:        strings.ToLower(string(c.Type)),
+				Required:    c.Options&ColumnRequired != 0,
+				Index:       c.Options&ColumnIndex != 0,
+				Hidden:      c.Options&ColumnHidden != 0,
+			})
+		}
+		out = append(out, st)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,116 @@
+package extcommon
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+var schemaTestTables = Tables{
+	"widgets": {
+		Description: "Widgets on the system.",
+		Columns: Table[testRow]{
+			TextColumn("name", func(r testRow) string { return r.name }).Describe("Name of the widget").
+				WithOptions(ColumnRequired | ColumnIndex),
+			BigIntColumn("size", func(r testRow) int64 { return r.size }).Describe(`Size, in "units" | bytes`),
+		}.Columns,
+	},
+	"gadgets": {
+		Schema: func() []table.ColumnDefinition {
+			return []table.ColumnDefinition{table.IntegerColumn("enabled")}
+		},
+	},
+}
+
+func TestTableSpecColumns(t *testing.T) {
+	assert.Equal(t, []table.ColumnDefinition{
+		table.TextColumn("name"),
+		table.BigIntColumn("size"),
+	}, schemaTestTables["widgets"].schema())
+	assert.Equal(t, []ColumnInfo{
+		{table.IntegerColumn("enabled"), "", ColumnDefault},
+	}, schemaTestTables["gadgets"].columns())
+}
+
+func TestDumpSchema(t *testing.T) {
+	testCases := []struct {
+		format string
+		output string
+	}{
+		{"spec", `table_name("gadgets")
+description("")
+schema([
+    Column("enabled", INTEGER, ""),
+])
+
+table_name("widgets")
+description("Widgets on the system.")
+schema([
+    Column("name", TEXT, "Name of the widget", index=True, required=True),
+    Column("size", BIGINT, "Size, in \"units\" | bytes"),
+])
+`},
+		{"markdown", "#### `gadgets`\n\n" +
+			"| Column | Type | Description |\n" +
+			"| --- | --- | --- |\n" +
+			"| `enabled` | INTEGER |  |\n" +
+			"\n" +
+			"#### `widgets`\n\n" +
+			"Widgets on the system.\n\n" +
+			"| Column | Type | Description |\n" +
+			"| --- | --- | --- |\n" +
+			"| `name` | TEXT (index, required) | Name of the widget |\n" +
+			"| `size` | BIGINT | Size, in \"units\" \\| bytes |\n"},
+		{"json", `[
+  {
+    "name": "gadgets",
+    "description": "",
+    "columns": [
+      {
+        "name": "enabled",
+        "description": "",
+        "type": "integer",
+        "required": false,
+        "index": false,
+        "hidden": false
+      }
+    ]
+  },
+  {
+    "name": "widgets",
+    "description": "Widgets on the system.",
+    "columns": [
+      {
+        "name": "name",
+        "description": "Name of the widget",
+        "type": "text",
+        "required": true,
+        "index": true,
+        "hidden": false
+      },
+      {
+        "name": "size",
+        "description": "Size, in \"units\" | bytes",
+        "type": "bigint",
+        "required": false,
+        "index": false,
+        "hidden": false
+      }
+    ]
+  }
+]
+`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			var b bytes.Buffer
+			assert.NoError(t, dumpSchema(&b, schemaTestTables, tc.format))
+			assert.Equal(t, tc.output, b.String())
+		})
+	}
+
+	assert.Error(t, dumpSchema(&bytes.Buffer{}, schemaTestTables, "yaml"))
+}
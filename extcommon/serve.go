@@ -0,0 +1,141 @@
+package extcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/osquery/osquery-go"
+)
+
+const (
+	// connectTimeout is how long to wait for the osquery extensions socket to accept a connection.
+	connectTimeout = time.Second
+	// minBackoff and maxBackoff bound the delay between attempts to reconnect to osquery.
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	// PingInterval is how often osquery is pinged to detect that it has gone away.
+	PingInterval = 5 * time.Second
+	// MaxRetries is the number of consecutive failed attempts to connect to osquery after which the
+	// extension gives up. If negative, the extension retries forever.
+	MaxRetries = -1
+)
+
+var errOsqueryGone = errors.New("osquery stopped responding to pings")
+
+// backoff returns the delay before the given retry, starting from 1, doubling the delay after
+// each attempt up to maxBackoff.
+func backoff(retry int) time.Duration {
+	d := minBackoff
+	for i := 1; i < retry && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// Serve serves the tables to the osquery instance listening on socket until ctx is done,
+// reconnecting and registering the tables again whenever the connection is lost, e.g. because
+// osqueryd restarted. It returns an error if it gives up reconnecting after MaxRetries attempts.
+func Serve(ctx context.Context, pluginName, socket string, t Tables) error {
+	// the plugins are built once, so that the result caches, coalesced queries and metrics of the
+	// tables are kept across reconnections
+	plugins := make([]*tablePlugin, 0, len(t))
+	for name, t := range t {
+		plugins = append(plugins, newTablePlugin(name, t.columns(), wrapGenerate(name, t)))
+	}
+
+	retry := 0
+	for {
+		registered, err := serve(ctx, pluginName, socket, plugins)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if registered {
+			// we were connected, so this is a new outage rather than a continuation of the last one
+			retry = 0
+		}
+		retry++
+		if MaxRetries >= 0 && retry > MaxRetries {
+			return err
+		}
+
+		d := backoff(retry)
+		if err == nil {
+			slog.Info("osquery shut down the extension, reconnecting", "delay", d)
+		} else {
+			slog.Warn("lost connection to osquery, reconnecting", "delay", d, "retry", retry, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(d):
+		}
+	}
+}
+
+// serve connects to osquery, registers the tables and serves them until ctx is done, osquery
+// stops responding to pings or asks the extension to shut down. registered reports whether the
+// tables were successfully registered.
+func serve(ctx context.Context, pluginName, socket string, plugins []*tablePlugin) (registered bool, err error) {
+	// the server's own client is busy while it registers, so ping osquery over a separate connection
+	pinger, err := osquery.NewClient(socket, connectTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer pinger.Close()
+
+	server, err := osquery.NewExtensionManagerServer(pluginName, socket,
+		osquery.ServerTimeout(connectTimeout), osquery.ServerPingInterval(PingInterval))
+	if err != nil {
+		return false, err
+	}
+	for _, p := range plugins {
+		server.RegisterPlugin(p)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Start()
+	}()
+
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err = <-errc:
+			// Start returns nil only if it registered the tables and osquery later asked us to shut down
+			return err == nil, err
+		case <-ctx.Done():
+			slog.Info("shutting down server")
+		case <-ticker.C:
+			status, perr := pinger.PingContext(ctx)
+			if perr == nil && status.Code == 0 {
+				registered = true
+				continue
+			}
+			if perr == nil {
+				perr = fmt.Errorf("status %d: %s", status.Code, status.Message)
+			}
+			if ctx.Err() == nil {
+				err = fmt.Errorf("%w: %v", errOsqueryGone, perr)
+			}
+		}
+		break
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if serr := server.Shutdown(shutdownCtx); serr != nil && err == nil && ctx.Err() == nil {
+		err = serr
+	}
+	select {
+	case <-errc:
+	case <-time.After(abandonAfter):
+	}
+	return registered, err
+}
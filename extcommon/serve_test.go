@@ -0,0 +1,32 @@
+package extcommon
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, minBackoff, backoff(1))
+	assert.Equal(t, 2*minBackoff, backoff(2))
+	assert.Equal(t, 4*minBackoff, backoff(3))
+	assert.Equal(t, maxBackoff, backoff(100))
+}
+
+func TestServeForeverGivesUp(t *testing.T) {
+	defer func(n int) { MaxRetries = n }(MaxRetries)
+	socket := path.Join(t.TempDir(), "osquery.em")
+
+	MaxRetries = 0
+	err := Serve(context.Background(), "test", socket, Tables{})
+	assert.Error(t, err)
+
+	// a cancelled context stops retrying without an error
+	MaxRetries = -1
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, Serve(ctx, "test", socket, Tables{}))
+}
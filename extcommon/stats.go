@@ -0,0 +1,323 @@
+package extcommon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// StatsTableName returns the name of the table exposing the query metrics of the named extension.
+// Each extension serves its own table rather than a single osquery_extension_stats table, since
+// osquery refuses to register a table which another extension has already registered, so only one
+// of several extensions served by the same osqueryd could serve it.
+func StatsTableName(extension string) string {
+	return extension + "_extension_stats"
+}
+
+// latencyBuckets are the upper bounds of the buckets of the query latency histograms.
+var latencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// tableStats are the query metrics of a single table.
+type tableStats struct {
+	mu          sync.Mutex
+	calls       int64
+	rows        int64
+	errors      int64
+	partial     int64
+	cacheHits   int64
+	cacheMisses int64
+	// latency counts the queries in each of latencyBuckets, followed by those slower than all of
+	// them.
+	latency    [len(latencyBuckets) + 1]int64
+	latencySum time.Duration
+	latencyMax time.Duration
+}
+
+var (
+	stats   = map[string]*tableStats{}
+	statsMu sync.Mutex
+)
+
+// statsFor returns the metrics of the named table.
+func statsFor(name string) *tableStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	s, ok := stats[name]
+	if !ok {
+		s = &tableStats{}
+		stats[name] = s
+	}
+	return s
+}
+
+// statsSnapshot returns a copy of the metrics of every table which is served, sorted by
+// table name.
+func statsSnapshot() (names []string, out []tableStats) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	for name := range stats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		out = append(out, stats[name].snapshot())
+	}
+	return
+}
+
+// recordQuery records the outcome of a query.
+func (s *tableStats) recordQuery(rows int, err error, partial bool, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	s.rows += int64(rows)
+	if err != nil {
+		s.errors++
+	}
+	if partial {
+		s.partial++
+	}
+	i, _ := slices.BinarySearch(latencyBuckets[:], d)
+	s.latency[i]++
+	s.latencySum += d
+	s.latencyMax = max(s.latencyMax, d)
+}
+
+// recordCache records whether a query was answered from the cache.
+func (s *tableStats) recordCache(hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hit {
+		s.cacheHits++
+	} else {
+		s.cacheMisses++
+	}
+}
+
+func (s *tableStats) snapshot() tableStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return tableStats{
+		calls:       s.calls,
+		rows:        s.rows,
+		errors:      s.errors,
+		partial:     s.partial,
+		cacheHits:   s.cacheHits,
+		cacheMisses: s.cacheMisses,
+		latency:     s.latency,
+		latencySum:  s.latencySum,
+		latencyMax:  s.latencyMax,
+	}
+}
+
+// latencyQuantile estimates a quantile of the query latency as the upper bound of the histogram
+// bucket it falls into, or the maximum latency if it falls into the last bucket.
+func (s *tableStats) latencyQuantile(q float64) time.Duration {
+	if s.calls == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.calls))
+	var n int64
+	for i, c := range s.latency {
+		if n += c; n > rank && i < len(latencyBuckets) {
+			return min(latencyBuckets[i], s.latencyMax)
+		}
+	}
+	return s.latencyMax
+}
+
+// cacheHitPct returns the percentage of cacheable queries which were answered from the cache.
+func (s *tableStats) cacheHitPct() int64 {
+	if total := s.cacheHits + s.cacheMisses; total > 0 {
+		return s.cacheHits * 100 / total
+	}
+	return 0
+}
+
+type statsEntry struct {
+	extension, name string
+	*tableStats
+}
+
+var statsColumns = Table[statsEntry]{
+	TextColumn("extension", func(e statsEntry) string { return e.extension }).Describe("Name of the extension serving the table"),
+	TextColumn("name", func(e statsEntry) string { return e.name }).Describe("Name of the table"),
+	BigIntColumn("calls", func(e statsEntry) int64 { return e.calls }).Describe("Number of queries against the table"),
+	BigIntColumn("rows", func(e statsEntry) int64 { return e.rows }).Describe("Number of rows returned by queries"),
+	BigIntColumn("errors", func(e statsEntry) int64 { return e.errors }).Describe("Number of queries which failed"),
+	BigIntColumn("partial_results", func(e statsEntry) int64 { return e.partial }).Describe("Number of queries which timed out and returned partial results"),
+	BigIntColumn("cache_hits", func(e statsEntry) int64 { return e.cacheHits }).Describe("Number of queries answered from the cache"),
+	BigIntColumn("cache_misses", func(e statsEntry) int64 { return e.cacheMisses }).Describe("Number of cacheable queries which had to be generated"),
+	IntegerColumn("cache_hit_pct", func(e statsEntry) int64 { return e.cacheHitPct() }).Describe("Percentage of cacheable queries answered from the cache"),
+	BigIntColumn("latency_avg_ms", func(e statsEntry) int64 {
+		if e.calls == 0 {
+			return 0
+		}
+		return (e.latencySum / time.Duration(e.calls)).Milliseconds()
+	}).Describe("Average query latency, in milliseconds"),
+	BigIntColumn("latency_p50_ms", func(e statsEntry) int64 { return e.latencyQuantile(0.5).Milliseconds() }).Describe("Median query latency, in milliseconds, estimated from the histogram"),
+	BigIntColumn("latency_p99_ms", func(e statsEntry) int64 { return e.latencyQuantile(0.99).Milliseconds() }).Describe("99th percentile of query latency, in milliseconds, estimated from the histogram"),
+	BigIntColumn("latency_max_ms", func(e statsEntry) int64 { return e.latencyMax.Milliseconds() }).Describe("Maximum query latency, in milliseconds"),
+	TextColumn("latency_histogram", func(e statsEntry) string { return e.histogram() }).Describe(`Comma-separated cumulative latency histogram of "upper bound:count" pairs`),
+}
+
+// histogram returns the latency histogram as a comma-separated list of "upper bound:count" pairs,
+// with cumulative counts as in Prometheus histograms.
+func (s *tableStats) histogram() string {
+	var b strings.Builder
+	var n int64
+	for i, c := range s.latency {
+		n += c
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if i < len(latencyBuckets) {
+			fmt.Fprintf(&b, "%s:%d", latencyBuckets[i], n)
+		} else {
+			fmt.Fprintf(&b, "+Inf:%d", n)
+		}
+	}
+	return b.String()
+}
+
+// statsTable returns a table listing the query metrics of each of the tables in t.
+func statsTable(extension string, t Tables) TableSpec {
+	return TableSpec{
+		Description: "Query metrics of each table served by the extension.",
+		Columns:     statsColumns.Columns,
+		Generate: func(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
+			cols := statsColumns.ForQuery(ctx, q)
+			names, snapshots := statsSnapshot()
+			for i, name := range names {
+				if _, ok := t[name]; !ok {
+					continue
+				}
+				if row, ok, err := cols.Row(q, statsEntry{extension, name, &snapshots[i]}); err != nil {
+					return nil, err
+				} else if ok {
+					out = append(out, row)
+				}
+			}
+			return
+		},
+	}
+}
+
+// writePrometheus writes the query metrics of every table in the Prometheus text format.
+func writePrometheus(w io.Writer, extension string) error {
+	names, snapshots := statsSnapshot()
+
+	counters := []struct {
+		name, help string
+		get        func(*tableStats) int64
+	}{
+		{"calls_total", "Number of queries of the table.", func(s *tableStats) int64 { return s.calls }},
+		{"rows_total", "Number of rows returned by queries of the table.", func(s *tableStats) int64 { return s.rows }},
+		{"errors_total", "Number of queries of the table which failed.", func(s *tableStats) int64 { return s.errors }},
+		{"partial_results_total", "Number of queries of the table which were interrupted and returned partial results.", func(s *tableStats) int64 { return s.partial }},
+		{"cache_hits_total", "Number of queries of the table answered from the cache.", func(s *tableStats) int64 { return s.cacheHits }},
+		{"cache_misses_total", "Number of cacheable queries of the table not answered from the cache.", func(s *tableStats) int64 { return s.cacheMisses }},
+	}
+
+	var b strings.Builder
+	labels := func(name string) string {
+		return fmt.Sprintf("extension=%q,table=%q", extension, name)
+	}
+	for _, c := range counters {
+		fmt.Fprintf(&b, "# HELP osquery_extension_%s %s\n", c.name, c.help)
+		fmt.Fprintf(&b, "# TYPE osquery_extension_%s counter\n", c.name)
+		for i, name := range names {
+			fmt.Fprintf(&b, "osquery_extension_%s{%s} %d\n", c.name, labels(name), c.get(&snapshots[i]))
+		}
+	}
+
+	const histogram = "osquery_extension_query_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Latency of queries of the table.\n", histogram)
+	fmt.Fprintf(&b, "# TYPE %s histogram\n", histogram)
+	for i, name := range names {
+		s := &snapshots[i]
+		var n int64
+		for j, c := range s.latency {
+			n += c
+			le := "+Inf"
+			if j < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[j].Seconds(), 'f', -1, 64)
+			}
+			fmt.Fprintf(&b, "%s_bucket{%s,le=%q} %d\n", histogram, labels(name), le, n)
+		}
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", histogram, labels(name), strconv.FormatFloat(s.latencySum.Seconds(), 'f', -1, 64))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", histogram, labels(name), s.calls)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writePrometheusFile atomically replaces path with the query metrics in the Prometheus text
+// format, so that node_exporter's textfile collector never reads a partially written file.
+func writePrometheusFile(path, extension string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := writePrometheus(f, extension); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// dumpStats writes the query metrics to path every interval until ctx is done, and once more
+// before returning.
+func dumpStats(ctx context.Context, path, extension string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := writePrometheusFile(path, extension); err != nil {
+			slog.Warn("failed to write stats", "path", path, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			if err := writePrometheusFile(path, extension); err != nil {
+				slog.Warn("failed to write stats", "path", path, "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package extcommon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableStats(t *testing.T) {
+	s := &tableStats{}
+	s.recordQuery(3, nil, false, 2*time.Millisecond)
+	s.recordQuery(0, errors.New("boom"), false, 20*time.Millisecond)
+	s.recordQuery(1, nil, true, time.Minute)
+	s.recordCache(true)
+	s.recordCache(false)
+	s.recordCache(false)
+	s.recordCache(false)
+
+	assert.Equal(t, int64(3), s.calls)
+	assert.Equal(t, int64(4), s.rows)
+	assert.Equal(t, int64(1), s.errors)
+	assert.Equal(t, int64(1), s.partial)
+	assert.Equal(t, int64(25), s.cacheHitPct())
+	assert.Equal(t, 50*time.Millisecond, s.latencyQuantile(0.5))
+	assert.Equal(t, time.Minute, s.latencyQuantile(0.99))
+	assert.Equal(t, "1ms:0,5ms:1,10ms:1,50ms:2,100ms:2,500ms:2,1s:2,5s:2,10s:2,30s:2,+Inf:3", s.histogram())
+}
+
+func TestStatsTable(t *testing.T) {
+	name := "test_stats_table"
+	t.Cleanup(func() {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		delete(stats, name)
+	})
+	g := wrapGenerate(name, TableSpec{
+		Generate: func(context.Context, table.QueryContext) ([]map[string]string, error) {
+			return []map[string]string{{"a": "1"}, {"a": "2"}}, nil
+		},
+	})
+	_, _ = g(context.Background(), table.QueryContext{})
+	_, _ = g(context.Background(), table.QueryContext{})
+
+	rows, err := statsTable("test", Tables{name: {}}).Generate(WithUsedColumns(context.Background(), []string{"calls", "rows"}),
+		table.QueryContext{Constraints: map[string]table.ConstraintList{
+			"name": constraints(table.Constraint{Operator: table.OperatorEquals, Expression: name}),
+		}})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"name": name, "calls": "2", "rows": "4"}}, rows)
+
+	statsFile := path.Join(t.TempDir(), "osquery.prom")
+	assert.NoError(t, writePrometheusFile(statsFile, "test"))
+	b, err := os.ReadFile(statsFile)
+	assert.NoError(t, err)
+	prom := string(b)
+	assert.Contains(t, prom, "# TYPE osquery_extension_calls_total counter\n")
+	assert.Contains(t, prom, `osquery_extension_calls_total{extension="test",table="test_stats_table"} 2`+"\n")
+	assert.Contains(t, prom, `osquery_extension_query_duration_seconds_bucket{extension="test",table="test_stats_table",le="+Inf"} 2`+"\n")
+	assert.Contains(t, prom, `osquery_extension_query_duration_seconds_count{extension="test",table="test_stats_table"} 2`+"\n")
+	for _, line := range strings.Split(strings.TrimSpace(prom), "\n") {
+		assert.Regexp(t, `^(# (HELP|TYPE) \S+ .+|[a-z_]+\{[^}]*\} [0-9.e+-]+)$`, line)
+	}
+}
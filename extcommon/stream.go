@@ -0,0 +1,141 @@
+package extcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// YieldFunc receives a row from a StreamFunc. It returns false when the generator should stop,
+// because the query's LIMIT or the table's limits have been reached or the query was cancelled.
+type YieldFunc = func(row map[string]string) bool
+
+// StreamFunc generates a table's rows one at a time by passing them to yield, so that the
+// framework can stop it as soon as it has enough rows.
+type StreamFunc = func(ctx context.Context, q table.QueryContext, yield YieldFunc) error
+
+// ErrResultTooLarge is returned for queries whose results exceed the row or memory limit of the
+// table, unless the table returns partial results.
+var ErrResultTooLarge = errors.New("query result exceeds limit")
+
+var (
+	// MaxRows is the default maximum number of rows a query may return. If zero or less, the
+	// number of rows isn't limited.
+	MaxRows int
+	// MaxBytes is the default maximum size of the rows a query may return, in bytes. If zero or
+	// less, the size isn't limited.
+	MaxBytes int64
+)
+
+// rowOverhead approximates the memory used by a row's map and each of its entries, in addition
+// to the contents of its strings.
+const (
+	rowOverhead   = 48
+	entryOverhead = 32
+)
+
+// rowSize returns the approximate memory used by a row, in bytes. Column names aren't counted,
+// since they're shared by every row.
+func rowSize(row map[string]string) int64 {
+	n := int64(rowOverhead)
+	for _, v := range row {
+		n += entryOverhead + int64(len(v))
+	}
+	return n
+}
+
+// QueryLimit returns the number of rows a query needs, i.e. the sum of its LIMIT and OFFSET, if
+// osquery passed them and the query has no other constraints. Otherwise, SQLite may filter out
+// some of the rows the extension returns, so the number of rows needed isn't known.
+func QueryLimit(q table.QueryContext) (n int, ok bool) {
+	for _, cl := range q.Constraints {
+		for _, c := range cl.Constraints {
+			switch c.Operator {
+			case OperatorLimit, OperatorOffset:
+				i, err := strconv.Atoi(c.Expression)
+				if err != nil || i < 0 {
+					return 0, false
+				}
+				n += i
+				ok = ok || c.Operator == OperatorLimit
+			default:
+				return 0, false
+			}
+		}
+	}
+	return n, ok
+}
+
+// maxRows returns the table's maximum number of rows per query.
+func (t TableSpec) maxRows() int {
+	if t.MaxRows != 0 {
+		return t.MaxRows
+	}
+	return MaxRows
+}
+
+// maxBytes returns the table's maximum result size per query.
+func (t TableSpec) maxBytes() int64 {
+	if t.MaxBytes != 0 {
+		return t.MaxBytes
+	}
+	return MaxBytes
+}
+
+// generator returns a function generating the table's rows, which stops once it has as many
+// rows as the query's LIMIT needs and fails with ErrResultTooLarge, returning the rows generated
+// so far, once the table's limits are exceeded.
+func (t TableSpec) generator() GenerateFunc {
+	stream, streaming := t.Stream, t.Stream != nil
+	if !streaming {
+		generate := t.Generate
+		stream = func(ctx context.Context, q table.QueryContext, yield YieldFunc) error {
+			rows, err := generate(ctx, q)
+			for _, row := range rows {
+				if !yield(row) {
+					break
+				}
+			}
+			return err
+		}
+	}
+
+	return func(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
+		maxRows, maxBytes := t.maxRows(), t.maxBytes()
+		limit, hasLimit := QueryLimit(q)
+		var size int64
+		var stopped error
+		err = stream(ctx, q, func(row map[string]string) bool {
+			// rows returned by Generate are kept even if they were generated after a timeout, in
+			// case the table returns partial results
+			if streaming {
+				if stopped = ctx.Err(); stopped != nil {
+					return false
+				}
+			}
+			if hasLimit && len(out) >= limit {
+				return false
+			}
+			if maxRows > 0 && len(out) >= maxRows {
+				stopped = fmt.Errorf("%w: more than %d rows", ErrResultTooLarge, maxRows)
+				return false
+			}
+			if maxBytes > 0 {
+				if size += rowSize(row); size > maxBytes {
+					stopped = fmt.Errorf("%w: more than %d bytes", ErrResultTooLarge, maxBytes)
+					return false
+				}
+			}
+			out = append(out, row)
+			return !hasLimit || len(out) < limit
+		})
+		if err == nil {
+			// the generator may not report why it stopped, e.g. because the context is done
+			err = stopped
+		}
+		return out, err
+	}
+}
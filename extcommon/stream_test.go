@@ -0,0 +1,133 @@
+package extcommon
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func limitConstraints(limit, offset string) map[string]table.ConstraintList {
+	c := constraints(table.Constraint{Operator: OperatorLimit, Expression: limit})
+	if offset != "" {
+		c.Constraints = append(c.Constraints, table.Constraint{Operator: OperatorOffset, Expression: offset})
+	}
+	return map[string]table.ConstraintList{"name": c}
+}
+
+func TestQueryLimit(t *testing.T) {
+	testCases := []struct {
+		name        string
+		constraints map[string]table.ConstraintList
+		expectN     int
+		expectOk    bool
+	}{
+		{"none", nil, 0, false},
+		{"limit", limitConstraints("10", ""), 10, true},
+		{"limit and offset", limitConstraints("10", "5"), 15, true},
+		{"invalid", limitConstraints("x", ""), 0, false},
+		{"other constraint", map[string]table.ConstraintList{
+			"name": constraints(
+				table.Constraint{Operator: OperatorLimit, Expression: "10"},
+				table.Constraint{Operator: table.OperatorEquals, Expression: "foo"},
+			),
+		}, 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, ok := QueryLimit(table.QueryContext{Constraints: tc.constraints})
+			assert.Equal(t, tc.expectN, n)
+			assert.Equal(t, tc.expectOk, ok)
+		})
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	defer func(r int, b int64) { MaxRows, MaxBytes = r, b }(MaxRows, MaxBytes)
+	MaxRows, MaxBytes = 0, 0
+
+	// countingStream yields up to 100 rows, recording how many it generated
+	var generated int
+	countingStream := func(ctx context.Context, q table.QueryContext, yield YieldFunc) error {
+		generated = 0
+		for i := range 100 {
+			generated++
+			if more, err := testTable.Yield(q, testRow{fmt.Sprint(i), 100, true}, yield); !more {
+				return err
+			}
+		}
+		return nil
+	}
+
+	testCases := []struct {
+		name            string
+		spec            TableSpec
+		constraints     map[string]table.ConstraintList
+		expectRows      int
+		expectGenerated int
+		expectErr       error
+	}{
+		{"unlimited", TableSpec{}, nil, 100, 100, nil},
+		{"limit", TableSpec{}, limitConstraints("10", ""), 10, 10, nil},
+		{"limit zero", TableSpec{}, limitConstraints("0", ""), 0, 1, nil},
+		{"max rows", TableSpec{MaxRows: 20}, nil, 20, 21, ErrResultTooLarge},
+		{"max rows disabled", TableSpec{MaxRows: -1}, nil, 100, 100, nil},
+		{"max bytes", TableSpec{MaxBytes: 1000}, nil, 6, 7, ErrResultTooLarge},
+		{"filtered", TableSpec{MaxRows: 20}, map[string]table.ConstraintList{
+			"name": constraints(table.Constraint{Operator: table.OperatorLike, Expression: "1%"}),
+		}, 11, 100, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.spec.Stream = countingStream
+			rows, err := tc.spec.generator()(context.Background(), table.QueryContext{Constraints: tc.constraints})
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Len(t, rows, tc.expectRows)
+			assert.Equal(t, tc.expectGenerated, generated)
+		})
+	}
+}
+
+func TestGeneratorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	spec := TableSpec{Stream: func(ctx context.Context, q table.QueryContext, yield YieldFunc) error {
+		yield(map[string]string{"name": "a"})
+		cancel()
+		yield(map[string]string{"name": "b"})
+		return nil
+	}}
+
+	rows, err := spec.generator()(ctx, table.QueryContext{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []map[string]string{{"name": "a"}}, rows)
+}
+
+func TestWrapGeneratePartialLimit(t *testing.T) {
+	stream := func(ctx context.Context, q table.QueryContext, yield YieldFunc) error {
+		for yield(map[string]string{"name": "a"}) {
+		}
+		return nil
+	}
+	t.Cleanup(func() {
+		statsMu.Lock()
+		delete(stats, "test_partial_limit")
+		statsMu.Unlock()
+	})
+
+	_, err := wrapGenerate("test_partial_limit", TableSpec{Stream: stream, MaxRows: 3})(
+		context.Background(), table.QueryContext{})
+	assert.ErrorIs(t, err, ErrResultTooLarge)
+
+	rows, err := wrapGenerate("test_partial_limit", TableSpec{Stream: stream, MaxRows: 3, PartialResults: true})(
+		context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+}
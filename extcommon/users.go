@@ -0,0 +1,138 @@
+package extcommon
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+const (
+	ColumnUID      = "uid"
+	ColumnUsername = "username"
+)
+
+// RootDir is the root of the filesystem from which the user database and home directories are
+// read.
+var RootDir = "/"
+
+// UserFilter restricts the users returned by ListUsers and UserDirs.
+type UserFilter struct {
+	// MinUID and MaxUID bound the UIDs of the returned users, inclusive. A MaxUID of 0 means there
+	// is no upper bound.
+	MinUID, MaxUID uint64
+	// LoginOnly excludes users whose login shell is unset, nologin or false.
+	LoginOnly bool
+	// UIDColumn and UsernameColumn are the columns whose constraints are honored by UserDirs.
+	// They default to ColumnUID and ColumnUsername.
+	UIDColumn, UsernameColumn string
+}
+
+// UserDir is a directory in a user's home directory.
+type UserDir struct {
+	User *user.User
+	Dir  string
+}
+
+// ListUsers lists local user accounts.
+func ListUsers(filter UserFilter) ([]*user.User, error) {
+	f, err := os.Open(path.Join(RootDir, "etc/passwd"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []*user.User
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(line, ":")
+		if len(fields) != 7 {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if uid < filter.MinUID || (filter.MaxUID > 0 && uid > filter.MaxUID) {
+			continue
+		}
+		if filter.LoginOnly && !isLoginShell(fields[6]) {
+			continue
+		}
+
+		name, _, _ := strings.Cut(fields[4], ",")
+		out = append(out, &user.User{
+			Uid:      fields[2],
+			Gid:      fields[3],
+			Username: fields[0],
+			Name:     name,
+			HomeDir:  fields[5],
+		})
+	}
+
+	return out, scanner.Err()
+}
+
+// UserDirs returns the directory at the relative path sub in the home directory of each user
+// selected by filter and by the query's constraints on the user columns, skipping users for whom
+// it doesn't exist.
+func UserDirs(ctx context.Context, q table.QueryContext, filter UserFilter, sub string) ([]UserDir, error) {
+	if filter.UIDColumn == "" {
+		filter.UIDColumn = ColumnUID
+	}
+	if filter.UsernameColumn == "" {
+		filter.UsernameColumn = ColumnUsername
+	}
+
+	users, err := ListUsers(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []UserDir
+	for _, u := range users {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+
+		uid, _ := strconv.ParseInt(u.Uid, 10, 64)
+		if m, err := MatchConstraints(IntValue(uid), q.Constraints[filter.UIDColumn]); !m {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if m, err := MatchConstraints(TextValue{V: u.Username}, q.Constraints[filter.UsernameColumn]); !m {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		dir := path.Join(RootDir, u.HomeDir, sub)
+		if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			out = append(out, UserDir{u, dir})
+		}
+	}
+
+	return out, nil
+}
+
+func isLoginShell(shell string) bool {
+	switch path.Base(shell) {
+	case "", ".", "/", "nologin", "false":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package extcommon
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPasswd = `# comment
+root:x:0:0:root:/root:/bin/bash
+bin:x:1:1::/:/usr/bin/nologin
+alice:x:1000:1000:Alice,,,:/home/alice:/bin/zsh
+bob:x:1001:1001:Bob:/home/bob:/bin/bash
+malformed line
+nobody:x:65534:65534:Kernel Overflow User:/:/usr/bin/nologin
+`
+
+func setupRootDir(t *testing.T) {
+	old := RootDir
+	t.Cleanup(func() { RootDir = old })
+
+	RootDir = t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(RootDir, "etc"), 0755))
+	assert.NoError(t, os.WriteFile(path.Join(RootDir, "etc/passwd"), []byte(testPasswd), 0644))
+	assert.NoError(t, os.MkdirAll(path.Join(RootDir, "home/alice/.config"), 0755))
+	assert.NoError(t, os.MkdirAll(path.Join(RootDir, "home/bob"), 0755))
+}
+
+func TestListUsers(t *testing.T) {
+	setupRootDir(t)
+
+	type testCase struct {
+		name   string
+		filter UserFilter
+		expect []string
+	}
+
+	var testCases = []*testCase{
+		{"all", UserFilter{}, []string{"root", "bin", "alice", "bob", "nobody"}},
+		{"uid range", UserFilter{MinUID: 1000, MaxUID: 60000}, []string{"alice", "bob"}},
+		{"login only", UserFilter{LoginOnly: true}, []string{"root", "alice", "bob"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			users, err := ListUsers(tc.filter)
+			assert.NoError(t, err)
+
+			var names []string
+			for _, u := range users {
+				names = append(names, u.Username)
+			}
+			assert.Equal(t, tc.expect, names)
+		})
+	}
+
+	users, err := ListUsers(UserFilter{MinUID: 1000, MaxUID: 1000})
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "Alice", users[0].Name)
+	assert.Equal(t, "/home/alice", users[0].HomeDir)
+}
+
+func TestUserDirs(t *testing.T) {
+	setupRootDir(t)
+
+	type testCase struct {
+		name        string
+		constraints map[string]table.ConstraintList
+		expect      []string
+	}
+
+	var testCases = []*testCase{
+		{"no constraints", nil, []string{"alice"}},
+		{
+			"uid",
+			map[string]table.ConstraintList{ColumnUID: {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: "1001"},
+			}}},
+			nil,
+		},
+		{
+			"username",
+			map[string]table.ConstraintList{ColumnUsername: {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: "alice"},
+			}}},
+			[]string{"alice"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dirs, err := UserDirs(context.Background(), table.QueryContext{Constraints: tc.constraints},
+				UserFilter{MinUID: 1000}, ".config")
+			assert.NoError(t, err)
+
+			var names []string
+			for _, d := range dirs {
+				names = append(names, d.User.Username)
+				assert.Equal(t, path.Join(RootDir, d.User.HomeDir, ".config"), d.Dir)
+			}
+			assert.Equal(t, tc.expect, names)
+		})
+	}
+}
@@ -2,7 +2,6 @@ package extcommon
 
 import (
 	"log"
-	"os/user"
 	"regexp"
 
 	"github.com/gobwas/glob"
@@ -59,11 +58,6 @@ func CompileRegexp(pattern string) (*regexp.Regexp, error) {
 	return r, nil
 }
 
-// ListUsers lists local user accounts.
-func ListUsers() ([]*user.User, error) {
-	return nil, nil
-}
-
 func init() {
 	var err error
 
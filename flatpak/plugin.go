@@ -2,8 +2,10 @@ package flatpak
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
 )
 
 const (
@@ -14,31 +16,41 @@ const (
 	ColumnHash    = "hash"
 	ColumnBranch  = "branch"
 	ColumnUser    = "user"
+	ColumnUID     = extcommon.ColumnUID
 )
 
-func Schema() (out []table.ColumnDefinition) {
-	return []table.ColumnDefinition{
-		table.TextColumn(ColumnID),
-		table.TextColumn(ColumnType),
-		table.TextColumn(ColumnName),
-		table.TextColumn(ColumnVersion),
-		table.TextColumn(ColumnHash),
-		table.TextColumn(ColumnBranch),
-		table.TextColumn(ColumnUser),
-	}
+var packagesTable = extcommon.Table[IPackage]{
+	extcommon.TextColumn(ColumnID, IPackage.Id).Describe(`Application or runtime ID, e.g. "org.mozilla.firefox"`),
+	extcommon.TextColumn(ColumnType, func(p IPackage) string { return string(p.Type()) }).Describe(`Either "app" or "runtime"`),
+	extcommon.TextColumn(ColumnName, IPackage.Name).Describe("Human-readable name of the package from its metadata, if any"),
+	extcommon.TextColumn(ColumnVersion, IPackage.Version).Describe("Version of the package from its metadata, if any"),
+	extcommon.TextColumn(ColumnHash, IPackage.Hash).Describe("Commit hash of the installed deployment"),
+	extcommon.TextColumn(ColumnBranch, IPackage.Branch).Describe(`Branch of the package, e.g. "stable"`),
+	extcommon.TextColumn(ColumnUser, IPackage.User).Describe("Name of the user the package is installed for; empty for system-wide installations"),
+	extcommon.NewColumn(table.BigIntColumn(ColumnUID), func(p IPackage) extcommon.Value {
+		uid, err := strconv.ParseInt(p.UID(), 10, 64)
+		if err != nil {
+			return extcommon.Null
+		}
+		return extcommon.IntValue(uid)
+	}).Describe("UID of the user the package is installed for; null for system-wide installations"),
+}
+
+func Schema() []table.ColumnDefinition {
+	return packagesTable.Schema()
 }
 
 func Generate(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
-	for _, pkg := range Packages() {
-		out = append(out, map[string]string{
-			ColumnID:      pkg.Id(),
-			ColumnType:    string(pkg.Type()),
-			ColumnName:    pkg.Name(),
-			ColumnVersion: pkg.Version(),
-			ColumnHash:    pkg.Hash(),
-			ColumnBranch:  pkg.Branch(),
-			ColumnUser:    pkg.User(),
-		})
+	pkgs, err := Packages(ctx, q)
+	tbl := packagesTable.ForQuery(ctx, q)
+	for _, pkg := range pkgs {
+		row, ok, rowErr := tbl.Row(q, pkg)
+		if rowErr != nil {
+			return nil, rowErr
+		}
+		if ok {
+			out = append(out, row)
+		}
 	}
 	return out, err
 }
@@ -1,18 +1,20 @@
 package flatpak
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/user"
 	"path"
 	"regexp"
 	"strings"
 
-	"github.com/linuxdeepin/go-lib/users/passwd"
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
 )
 
 type PackageType string
@@ -26,15 +28,19 @@ type IPackage interface {
 	Hash() string
 	Type() PackageType
 	User() string
+	UID() string
 }
 
 type packagePrimitive struct {
-	id     string
-	user   string
-	t      PackageType
-	arch   string
-	branch string
-	hash   string
+	id      string
+	user    *user.User
+	baseDir string
+	t       PackageType
+	arch    string
+	branch  string
+	hash    string
+	deploy  *DeployData
+	logger  *slog.Logger
 }
 
 type ArchitectureBranch struct {
@@ -55,6 +61,7 @@ const (
 var (
 	systemLocation = "/var/lib/flatpak"
 	userLocation   = ".local/share/flatpak"
+	userFilter     = extcommon.UserFilter{UsernameColumn: ColumnUser}
 
 	subpaths = []PackageType{
 		TypeApp,
@@ -63,24 +70,21 @@ var (
 	applicationIdRegexp = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)(\.([A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?))*$`)
 )
 
-func Packages() (out []IPackage) {
-	type scanLocation struct {
-		baseDir string
-		user    string
-	}
-
-	scanLocations := []scanLocation{
-		{systemLocation, ""},
+// Packages lists the packages installed system-wide and in the home directory of each user
+// selected by the query. If ctx is done before all locations have been scanned, the packages found
+// so far are returned along with the context's error.
+func Packages(ctx context.Context, q table.QueryContext) (out []IPackage, err error) {
+	locations, err := scanLocations(ctx, q)
+	if err != nil {
+		return
 	}
 
-	for _, entry := range passwd.GetPasswdEntry() {
-		userDir := path.Join(entry.Home, userLocation)
-		if st, err := os.Stat(userDir); err == nil && st.IsDir() {
-			scanLocations = append(scanLocations, scanLocation{userDir, entry.Name})
+	logger := extcommon.Logger(ctx)
+	for _, loc := range locations {
+		if err = ctx.Err(); err != nil {
+			return
 		}
-	}
 
-	for _, loc := range scanLocations {
 		for _, sub := range subpaths {
 			dir := path.Join(loc.baseDir, string(sub))
 			if entries, err := os.ReadDir(dir); err == nil {
@@ -89,15 +93,20 @@ func Packages() (out []IPackage) {
 						continue
 					}
 					pp := &packagePrimitive{
-						id:   entry.Name(),
-						user: loc.user,
-						t:    sub,
+						id:      entry.Name(),
+						user:    loc.user,
+						baseDir: loc.baseDir,
+						t:       sub,
+						logger:  logger.With("package", entry.Name(), "dir", loc.baseDir),
 					}
 
-					if ab, err := pp.architecturesAndBranches(); err == nil {
-						for _, ab := range ab {
-							out = append(out, pp.WithArchBranch(ab.Architecture, ab.Branch))
-						}
+					ab, err := pp.architecturesAndBranches()
+					if err != nil {
+						pp.logger.Debug("failed to list architectures and branches", "error", err)
+						continue
+					}
+					for _, ab := range ab {
+						out = append(out, pp.WithArchBranch(ab.Architecture, ab.Branch))
 					}
 				}
 			}
@@ -107,6 +116,56 @@ func Packages() (out []IPackage) {
 	return
 }
 
+// CacheStamp returns a cache stamp which changes whenever a flatpak package is installed, updated
+// or removed.
+func CacheStamp(q table.QueryContext) (string, error) {
+	locations, err := scanLocations(context.Background(), q)
+	if err != nil {
+		return "", err
+	}
+
+	var paths []string
+	for _, loc := range locations {
+		// flatpak touches this file whenever it modifies an installation
+		paths = append(paths, path.Join(loc.baseDir, ".changed"))
+		for _, sub := range subpaths {
+			paths = append(paths, path.Join(loc.baseDir, string(sub)))
+		}
+	}
+	return extcommon.FileStamp(paths...)
+}
+
+type scanLocation struct {
+	baseDir string
+	user    *user.User
+}
+
+// scanLocations returns the system-wide installation and each user's installation, if they have
+// one, honoring the query's constraints on the user columns.
+func scanLocations(ctx context.Context, q table.QueryContext) ([]scanLocation, error) {
+	var locations []scanLocation
+
+	// the system-wide installation has no user, so skip it if the query requires one
+	uidMatch, err := extcommon.MatchConstraints(extcommon.Null, q.Constraints[ColumnUID])
+	if err != nil {
+		return nil, err
+	}
+	userMatch, err := extcommon.MatchConstraints(extcommon.TextValue{}, q.Constraints[ColumnUser])
+	if err != nil {
+		return nil, err
+	}
+	if uidMatch && userMatch {
+		locations = append(locations, scanLocation{systemLocation, nil})
+	}
+
+	userDirs, err := extcommon.UserDirs(ctx, q, userFilter, userLocation)
+	for _, d := range userDirs {
+		locations = append(locations, scanLocation{d.Dir, d.User})
+	}
+
+	return locations, err
+}
+
 // Id implements IPackage
 func (pp *packagePrimitive) Id() string {
 	return pp.id
@@ -125,7 +184,7 @@ func (pp *packagePrimitive) Version() string {
 func (pp *packagePrimitive) getMetadataString(k string) string {
 	deploy, err := pp.parseDeployFile()
 	if err != nil {
-		log.Printf("failed to parseDeployFile: %+v", err)
+		pp.log().Debug("failed to parse deploy file", "error", err)
 		return ""
 	}
 
@@ -165,20 +224,29 @@ func (pp *packagePrimitive) Type() PackageType {
 
 // User implements IPackage
 func (pp *packagePrimitive) User() string {
-	return pp.user
+	if pp.user == nil {
+		return ""
+	}
+	return pp.user.Username
 }
 
-func (pp *packagePrimitive) dir() (string, error) {
-	if pp.user == "" {
-		return path.Join(systemLocation, string(pp.t), pp.id), nil
+// UID implements IPackage
+func (pp *packagePrimitive) UID() string {
+	if pp.user == nil {
+		return ""
 	}
+	return pp.user.Uid
+}
 
-	u, err := user.Lookup(pp.user)
-	if err != nil {
-		return "", err
+func (pp *packagePrimitive) log() *slog.Logger {
+	if pp.logger == nil {
+		return slog.Default()
 	}
+	return pp.logger
+}
 
-	return path.Join(u.HomeDir, userLocation, string(pp.t), pp.id), nil
+func (pp *packagePrimitive) dir() (string, error) {
+	return path.Join(pp.baseDir, string(pp.t), pp.id), nil
 }
 
 func (pp *packagePrimitive) currentArchitectureAndBranch() (string, string, error) {
@@ -277,6 +345,10 @@ func (pp *packagePrimitive) activeHash() (string, error) {
 }
 
 func (pp *packagePrimitive) parseDeployFile() (*DeployData, error) {
+	if pp.deploy != nil {
+		return pp.deploy, nil
+	}
+
 	if pp.branch == "" {
 		return nil, errors.New("branch is not set")
 	}
@@ -302,7 +374,8 @@ func (pp *packagePrimitive) parseDeployFile() (*DeployData, error) {
 		return nil, err
 	}
 
-	return LoadDeployData(contents)
+	pp.deploy, err = LoadDeployData(contents)
+	return pp.deploy, err
 }
 
 func subdirs(dir string) (out []string, err error) {
@@ -326,4 +399,12 @@ func init() {
 		"flatpak.system-dir",
 		systemLocation,
 		"directory where system-wide flatpak packages are installed")
+
+	extcommon.RegisterTable("flatpak_packages", extcommon.TableSpec{
+		Description:    "Flatpak applications and runtimes installed system-wide or for any user.",
+		Columns:        packagesTable.Columns,
+		Generate:       Generate,
+		PartialResults: true,
+		Cache:          &extcommon.CacheSpec{Stamp: CacheStamp},
+	})
 }
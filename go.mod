@@ -3,18 +3,20 @@ module go.fuhry.dev/osquery
 go 1.24.4
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/Jguer/go-alpm/v2 v2.2.2
+	github.com/apache/thrift v0.20.0
 	github.com/chrisportman/go-gvariant v0.0.4
 	github.com/gobwas/glob v0.2.3
 	github.com/hashicorp/golang-lru/v2 v2.0.7
-	github.com/linuxdeepin/go-lib v0.0.0-20250616085703-5b1a742a7af7
 	github.com/osquery/osquery-go v0.0.0-20250131154556-629f995b6947
 	github.com/stretchr/testify v1.10.0
+	golang.org/x/sync v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/Microsoft/go-winio v0.6.2 // indirect
-	github.com/apache/thrift v0.20.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-logr/logr v1.2.4 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
@@ -24,5 +26,4 @@ require (
 	go.opentelemetry.io/otel/metric v1.16.0 // indirect
 	go.opentelemetry.io/otel/trace v1.16.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
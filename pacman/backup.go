@@ -0,0 +1,86 @@
+package pacman
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/Jguer/go-alpm/v2"
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+const (
+	ColumnOriginalMD5   = "original_md5"
+	ColumnCurrentMD5    = "current_md5"
+	ColumnModified      = "modified"
+	ColumnPacnewExists  = "pacnew_exists"
+	ColumnPacsaveExists = "pacsave_exists"
+)
+
+// backupFile is a file which a package declares as a backup file, i.e. a configuration file
+// which pacman doesn't overwrite once it was modified.
+type backupFile struct {
+	pkg        localPackage
+	file       alpm.BackupFile
+	currentMD5 string
+}
+
+// exists reports whether the file with the given suffix appended to the backup file's path exists.
+func (b backupFile) exists(suffix string) bool {
+	_, err := os.Lstat(path.Join(b.pkg.h.rootDir, b.file.Name) + suffix)
+	return err == nil
+}
+
+var backupFilesTable = extcommon.Table[backupFile]{
+	extcommon.TextColumn(ColumnPackage, func(b backupFile) string { return b.pkg.Name() }).
+		Describe("Name of the package owning the file").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnPath, func(b backupFile) string { return b.file.Name }).
+		Describe("Path of the file, relative to the root directory").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnOriginalMD5, func(b backupFile) string { return b.file.Hash }).
+		Describe("MD5 hash of the file as installed by the package"),
+	extcommon.TextColumn(ColumnCurrentMD5, func(b backupFile) string { return b.currentMD5 }).
+		Describe("MD5 hash of the file on disk; empty if it's missing or can't be read"),
+	extcommon.BoolColumn(ColumnModified, func(b backupFile) bool { return b.currentMD5 != b.file.Hash }).
+		Describe("Set to 1 if the file differs from the one installed by the package, or is missing"),
+	extcommon.BoolColumn(ColumnPacnewExists, func(b backupFile) bool { return b.exists(".pacnew") }).
+		Describe("Set to 1 if a .pacnew file, installed by an upgrade instead of overwriting the modified file, exists"),
+	extcommon.BoolColumn(ColumnPacsaveExists, func(b backupFile) bool { return b.exists(".pacsave") }).
+		Describe("Set to 1 if a .pacsave file, saved when the modified file was removed or replaced, exists"),
+}
+
+// BackupFilesStream generates row data for the "pacman_backup_files" table.
+func BackupFilesStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	tbl := backupFilesTable.ForQuery(ctx, q)
+	return forEachPackage(ctx, func(pkg localPackage) error {
+		if m, err := backupFilesTable.Matches(q, backupFile{pkg: pkg}, ColumnPackage); !m {
+			return err
+		}
+
+		return pkg.Backup().ForEach(func(f alpm.BackupFile) error {
+			b := backupFile{pkg: pkg, file: f}
+			// filter on path before hashing the file
+			if m, err := backupFilesTable.Matches(q, b, ColumnPath); err != nil {
+				return err
+			} else if !m {
+				return nil
+			}
+
+			sum, err := hashFile(path.Join(pkg.h.rootDir, f.Name), md5.New())
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				extcommon.Logger(ctx).Debug("failed to hash backup file", "path", f.Name, "error", err)
+			}
+			b.currentMD5 = sum
+
+			if more, err := tbl.Yield(q, b, yield); !more {
+				return stopIteration(err)
+			}
+			return nil
+		})
+	})
+}
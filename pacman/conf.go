@@ -0,0 +1,353 @@
+package pacman
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+const (
+	ColumnOption      = "option"
+	ColumnValue       = "value"
+	ColumnFile        = "file"
+	ColumnSigLevel    = "sig_level"
+	ColumnServers     = "servers"
+	ColumnUsage       = "usage"
+	ColumnIncludeFile = "include_file"
+)
+
+// Defaults of pacman, used for the settings which neither flags nor pacman.conf set.
+const (
+	defaultRootDir = "/"
+	defaultDBPath  = "/var/lib/pacman"
+	defaultLogFile = "/var/log/pacman.log"
+)
+
+var configPath string = "/etc/pacman.conf"
+
+// maxIncludeDepth limits the nesting of Include directives, so that circular includes fail.
+const maxIncludeDepth = 10
+
+// configOption is a line of the [options] section of pacman.conf.
+type configOption struct {
+	name, value, file string
+}
+
+// repository is a repository section of pacman.conf.
+type repository struct {
+	name         string
+	sigLevel     []string
+	servers      []string
+	usage        []string
+	includeFiles []string
+}
+
+// pacmanConfig is the contents of pacman.conf and the files it includes.
+type pacmanConfig struct {
+	options []configOption
+	repos   []*repository
+	// files are the paths of every file read, for cache stamps
+	files []string
+}
+
+// parseConfig reads a pacman.conf file and the files it includes.
+func parseConfig(p string) (*pacmanConfig, error) {
+	c := &pacmanConfig{}
+	if _, _, err := c.parseFile(p, "", nil, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseFile parses the lines of a file as if they were in the given section, which is where the
+// file is included; repo is the section's repository, or nil for [options]. It returns the
+// section in effect at the end of the file since, as with pacman, a section started by an included
+// file continues after the Include line.
+func (c *pacmanConfig) parseFile(p, section string, repo *repository, depth int) (string, *repository, error) {
+	if depth > maxIncludeDepth {
+		return "", nil, fmt.Errorf("%s: too many levels of Include", p)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	c.files = append(c.files, p)
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(line, "["); ok {
+			name, ok = strings.CutSuffix(name, "]")
+			if !ok || name == "" {
+				return "", nil, fmt.Errorf("%s:%d: invalid section %q", p, n, line)
+			}
+			section, repo = name, nil
+			if section != "options" {
+				repo = &repository{name: section}
+				c.repos = append(c.repos, repo)
+			}
+			continue
+		}
+		if section == "" {
+			return "", nil, fmt.Errorf("%s:%d: %q isn't in a section", p, n, line)
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "Include" {
+			matches, err := filepath.Glob(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s:%d: %w", p, n, err)
+			}
+			if repo != nil {
+				repo.includeFiles = append(repo.includeFiles, value)
+			}
+			for _, m := range matches {
+				if section, repo, err = c.parseFile(m, section, repo, depth+1); err != nil {
+					return "", nil, err
+				}
+			}
+			continue
+		}
+
+		if repo == nil {
+			c.options = append(c.options, configOption{key, value, p})
+			continue
+		}
+		switch key {
+		case "Server":
+			repo.servers = append(repo.servers, value)
+		case "SigLevel":
+			repo.sigLevel = append(repo.sigLevel, strings.Fields(value)...)
+		case "Usage":
+			repo.usage = append(repo.usage, strings.Fields(value)...)
+		}
+	}
+	return section, repo, s.Err()
+}
+
+// option returns the value of the last occurrence of an option.
+func (c *pacmanConfig) option(name string) (value string, ok bool) {
+	for _, o := range c.options {
+		if o.name == name {
+			value, ok = o.value, true
+		}
+	}
+	return
+}
+
+// listOption returns the whitespace-separated values of every occurrence of an option.
+func (c *pacmanConfig) listOption(name string) (out []string) {
+	for _, o := range c.options {
+		if o.name == name {
+			out = append(out, strings.Fields(o.value)...)
+		}
+	}
+	return
+}
+
+// architectures returns the architectures of packages pacman installs, with "auto" replaced by
+// the architecture of the machine.
+func (c *pacmanConfig) architectures() (out []string) {
+	for _, a := range c.listOption("Architecture") {
+		if a == "auto" {
+			a = machineArch()
+		}
+		out = append(out, a)
+	}
+	return
+}
+
+// expandServer replaces the $repo and $arch variables of a repository's server URL.
+func (c *pacmanConfig) expandServer(r *repository, server string) string {
+	arch := machineArch()
+	if archs := c.architectures(); len(archs) > 0 {
+		arch = archs[0]
+	}
+	return strings.NewReplacer("$repo", r.name, "$arch", arch).Replace(server)
+}
+
+// machineArch returns the name pacman uses for the architecture of the machine.
+func machineArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	case "arm":
+		return "armv7h"
+	case "riscv64":
+		return "riscv64"
+	}
+	return runtime.GOARCH
+}
+
+// settings are the paths used by the pacman tables, from their flags, or else pacman.conf, or
+// else pacman's defaults.
+type settings struct {
+	rootDir, dbPath, logFile string
+	conf                     *pacmanConfig
+	// err is the error reading pacman.conf, if any, in which case conf is empty
+	err error
+}
+
+var (
+	confCache struct {
+		path, stamp string
+		conf        *pacmanConfig
+		err         error
+	}
+	confCacheMu sync.Mutex
+)
+
+// loadConfig returns the contents of pacman.conf, which are only parsed again when it or a file it
+// includes changes. A missing pacman.conf is treated as empty.
+func loadConfig() (*pacmanConfig, error) {
+	confCacheMu.Lock()
+	defer confCacheMu.Unlock()
+
+	if confCache.conf != nil && confCache.path == configPath {
+		stamp, _ := extcommon.FileStamp(confCache.conf.files...)
+		if stamp == confCache.stamp {
+			return confCache.conf, confCache.err
+		}
+	}
+
+	c, err := parseConfig(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		c, err = &pacmanConfig{files: []string{configPath}}, nil
+	} else if err != nil {
+		c = &pacmanConfig{files: []string{configPath}}
+	}
+	confCache.path, confCache.conf, confCache.err = configPath, c, err
+	confCache.stamp, _ = extcommon.FileStamp(c.files...)
+	return c, err
+}
+
+// currentSettings resolves the paths used by the pacman tables.
+func currentSettings() settings {
+	conf, err := loadConfig()
+	s := settings{rootDir: rootDir, dbPath: dbPath, logFile: logPath, conf: conf, err: err}
+
+	if s.rootDir == "" {
+		s.rootDir = defaultRootDir
+		if v, ok := conf.option("RootDir"); ok {
+			s.rootDir = v
+		}
+	}
+	// like pacman, the database and log default to their usual paths under the root directory
+	if s.dbPath == "" {
+		s.dbPath = path.Join(s.rootDir, defaultDBPath)
+		if v, ok := conf.option("DBPath"); ok {
+			s.dbPath = v
+		}
+	}
+	if s.logFile == "" {
+		s.logFile = path.Join(s.rootDir, defaultLogFile)
+		if v, ok := conf.option("LogFile"); ok {
+			s.logFile = v
+		}
+	}
+	return s
+}
+
+func (s settings) lockPath() string {
+	return path.Join(s.dbPath, "db.lck")
+}
+
+func (s settings) syncDBPath() string {
+	return path.Join(s.dbPath, "sync")
+}
+
+var configTable = extcommon.Table[configOption]{
+	extcommon.TextColumn(ColumnOption, func(o configOption) string { return o.name }).
+		Describe("Name of the option").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnValue, func(o configOption) string { return o.value }).
+		Describe("Value of the option; empty for options which are flags, such as Color"),
+	extcommon.TextColumn(ColumnFile, func(o configOption) string { return o.file }).
+		Describe("Path of the file the option is set in, which is pacman.conf unless it's included"),
+}
+
+// ConfigStream generates row data for the "pacman_config" table.
+func ConfigStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	tbl := configTable.ForQuery(ctx, q)
+	for _, o := range conf.options {
+		if more, err := tbl.Yield(q, o, yield); !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// configRepository is a repository of pacman.conf along with the config, which is needed to
+// expand its servers.
+type configRepository struct {
+	*repository
+	conf *pacmanConfig
+}
+
+var repositoriesTable = extcommon.Table[configRepository]{
+	extcommon.TextColumn(ColumnName, func(r configRepository) string { return r.name }).
+		Describe("Name of the repository"),
+	extcommon.TextColumn(ColumnSigLevel, func(r configRepository) string { return strings.Join(r.sigLevel, ",") }).
+		Describe("Comma-separated list of the SigLevel settings of the repository; empty if it uses the SigLevel of the [options] section"),
+	extcommon.TextColumn(ColumnServers, func(r configRepository) string {
+		servers := make([]string, 0, len(r.servers))
+		for _, s := range r.servers {
+			servers = append(servers, r.conf.expandServer(r.repository, s))
+		}
+		return strings.Join(servers, ",")
+	}).Describe("Comma-separated list of the URLs of the repository's servers, including those of included mirrorlists, in order of preference"),
+	extcommon.TextColumn(ColumnUsage, func(r configRepository) string { return strings.Join(r.usage, ",") }).
+		Describe(`Comma-separated list of the Usage settings of the repository, e.g. "Sync,Search"; empty if it's used for everything`),
+	extcommon.TextColumn(ColumnIncludeFile, func(r configRepository) string { return strings.Join(r.includeFiles, ",") }).
+		Describe("Comma-separated list of the files included by the repository's section, such as its mirrorlist"),
+}
+
+// RepositoriesStream generates row data for the "pacman_repositories" table.
+func RepositoriesStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	tbl := repositoriesTable.ForQuery(ctx, q)
+	for _, r := range conf.repos {
+		if more, err := tbl.Yield(q, configRepository{r, conf}, yield); !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfigCacheStamp returns a cache stamp which changes whenever pacman.conf or a file it includes
+// changes.
+func ConfigCacheStamp(table.QueryContext) (string, error) {
+	conf, _ := loadConfig()
+	return extcommon.FileStamp(conf.files...)
+}
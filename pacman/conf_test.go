@@ -0,0 +1,123 @@
+package pacman
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Jguer/go-alpm/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		p := path.Join(dir, name)
+		assert.NoError(t, os.WriteFile(p, []byte(contents), 0o644))
+		return p
+	}
+
+	mirrorlist := write("mirrorlist", `
+## Worldwide
+Server = https://geo.mirror.pkgbuild.com/$repo/os/$arch
+#Server = https://disabled.example.com/$repo/os/$arch
+Server = https://mirror.example.com/archlinux/$repo/os/$arch
+`)
+	write("options.conf", "Color\nIgnorePkg = linux-lts\n")
+	conf := write("pacman.conf", `
+[options]
+RootDir     = /mnt
+HoldPkg     = pacman glibc
+Architecture = x86_64 x86_64_v3
+IgnorePkg   = linux   # pinned
+Include = `+path.Join(dir, "*.conf.d")+`
+Include = `+path.Join(dir, "options.conf")+`
+SigLevel    = Required DatabaseOptional
+
+[core]
+Include = `+mirrorlist+`
+
+[custom]
+SigLevel = Optional TrustAll
+Usage = Sync Search
+Server = file:///srv/$repo
+`)
+
+	c, err := parseConfig(conf)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []configOption{
+		{"RootDir", "/mnt", conf},
+		{"HoldPkg", "pacman glibc", conf},
+		{"Architecture", "x86_64 x86_64_v3", conf},
+		{"IgnorePkg", "linux", conf},
+		{"Color", "", path.Join(dir, "options.conf")},
+		{"IgnorePkg", "linux-lts", path.Join(dir, "options.conf")},
+		{"SigLevel", "Required DatabaseOptional", conf},
+	}, c.options)
+	assert.Equal(t, []string{"linux", "linux-lts"}, c.listOption("IgnorePkg"))
+	assert.Equal(t, []string{"x86_64", "x86_64_v3"}, c.architectures())
+	assert.Equal(t, []string{conf, path.Join(dir, "options.conf"), mirrorlist}, c.files)
+
+	assert.Equal(t, []*repository{
+		{
+			name:         "core",
+			servers:      []string{"https://geo.mirror.pkgbuild.com/$repo/os/$arch", "https://mirror.example.com/archlinux/$repo/os/$arch"},
+			includeFiles: []string{mirrorlist},
+		},
+		{
+			name:     "custom",
+			sigLevel: []string{"Optional", "TrustAll"},
+			usage:    []string{"Sync", "Search"},
+			servers:  []string{"file:///srv/$repo"},
+		},
+	}, c.repos)
+	assert.Equal(t, "https://geo.mirror.pkgbuild.com/core/os/x86_64", c.expandServer(c.repos[0], c.repos[0].servers[0]))
+	assert.Equal(t, alpm.Usage(alpm.UsageAll), repositoryUsage(c.repos[0]))
+	assert.Equal(t, alpm.UsageSync|alpm.UsageSearch, repositoryUsage(c.repos[1]))
+
+	// like pacman, a section started by an included file continues after the Include line
+	extra := write("extra.inc", "[extra]\nServer = https://extra.example.com\n")
+	c, err = parseConfig(write("sections.conf", "[core]\nInclude = "+extra+"\nServer = https://after.example.com\n"+
+		"[options]\nColor\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []*repository{
+		{name: "core", includeFiles: []string{extra}},
+		{name: "extra", servers: []string{"https://extra.example.com", "https://after.example.com"}},
+	}, c.repos)
+	assert.Equal(t, []configOption{{"Color", "", path.Join(dir, "sections.conf")}}, c.options)
+
+	_, err = parseConfig(write("bad.conf", "Color\n"))
+	assert.ErrorContains(t, err, "isn't in a section")
+
+	loop := path.Join(dir, "loop.conf")
+	write("loop.conf", "[options]\nInclude = "+loop+"\n")
+	_, err = parseConfig(loop)
+	assert.ErrorContains(t, err, "too many levels of Include")
+}
+
+func TestCurrentSettings(t *testing.T) {
+	dir := t.TempDir()
+	defer func(c, r, d, l string) { configPath, rootDir, dbPath, logPath = c, r, d, l }(configPath, rootDir, dbPath, logPath)
+
+	configPath = path.Join(dir, "missing.conf")
+	rootDir, dbPath, logPath = "", "", ""
+	s := currentSettings()
+	assert.NoError(t, s.err)
+	assert.Equal(t, "/", s.rootDir)
+	assert.Equal(t, "/var/lib/pacman", s.dbPath)
+	assert.Equal(t, "/var/log/pacman.log", s.logFile)
+
+	configPath = path.Join(dir, "pacman.conf")
+	assert.NoError(t, os.WriteFile(configPath, []byte("[options]\nRootDir = /mnt\nLogFile = /tmp/pacman.log\n"), 0o644))
+	s = currentSettings()
+	assert.NoError(t, s.err)
+	assert.Equal(t, "/mnt", s.rootDir)
+	assert.Equal(t, "/mnt/var/lib/pacman", s.dbPath)
+	assert.Equal(t, "/tmp/pacman.log", s.logFile)
+
+	// flags take precedence over the config
+	dbPath = "/srv/pacman"
+	s = currentSettings()
+	assert.Equal(t, "/srv/pacman", s.dbPath)
+}
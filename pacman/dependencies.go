@@ -0,0 +1,99 @@
+package pacman
+
+import (
+	"context"
+
+	"github.com/Jguer/go-alpm/v2"
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+const (
+	ColumnKind              = "kind"
+	ColumnVersionConstraint = "version_constraint"
+)
+
+// Kinds of relations of the "pacman_package_dependencies" table.
+const (
+	KindDepends      = "depends"
+	KindOptDepends   = "optdepends"
+	KindMakeDepends  = "makedepends"
+	KindCheckDepends = "checkdepends"
+	KindProvides     = "provides"
+	KindConflicts    = "conflicts"
+	KindReplaces     = "replaces"
+	KindRequiredBy   = "required_by"
+)
+
+// dependency is a relation of a package to another package.
+type dependency struct {
+	pkg  alpm.IPackage
+	kind string
+	dep  alpm.Depend
+}
+
+// relations lists the kinds of relations of a package, in the order their rows are generated.
+var relations = []struct {
+	kind string
+	list func(alpm.IPackage) []alpm.Depend
+}{
+	{KindDepends, func(p alpm.IPackage) []alpm.Depend { return p.Depends().Slice() }},
+	{KindOptDepends, func(p alpm.IPackage) []alpm.Depend { return p.OptionalDepends().Slice() }},
+	{KindMakeDepends, func(p alpm.IPackage) []alpm.Depend { return p.MakeDepends().Slice() }},
+	{KindCheckDepends, func(p alpm.IPackage) []alpm.Depend { return p.CheckDepends().Slice() }},
+	{KindProvides, func(p alpm.IPackage) []alpm.Depend { return p.Provides().Slice() }},
+	{KindConflicts, func(p alpm.IPackage) []alpm.Depend { return p.Conflicts().Slice() }},
+	{KindReplaces, func(p alpm.IPackage) []alpm.Depend { return p.Replaces().Slice() }},
+	{KindRequiredBy, func(p alpm.IPackage) (out []alpm.Depend) {
+		// computed by checking the dependencies of every other package, so only on demand
+		for _, name := range p.ComputeRequiredBy() {
+			out = append(out, alpm.Depend{Name: name, Mod: alpm.DepModAny})
+		}
+		return
+	}},
+}
+
+var dependenciesTable = extcommon.Table[dependency]{
+	extcommon.TextColumn(ColumnPackage, func(d dependency) string { return d.pkg.Name() }).
+		Describe("Name of the package").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnKind, func(d dependency) string { return d.kind }).
+		Describe(`Kind of relation: "depends", "optdepends", "makedepends", "checkdepends", "provides", "conflicts", "replaces", or "required_by" for installed packages which depend on the package`),
+	extcommon.TextColumn(ColumnName, func(d dependency) string { return d.dep.Name }).
+		Describe("Name of the related package, or of the virtual package provided").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnVersionConstraint, func(d dependency) string {
+		if d.dep.Mod == alpm.DepModAny {
+			return ""
+		}
+		return d.dep.Mod.String() + d.dep.Version
+	}).Describe(`Constraint on the version of the related package, e.g. ">=2.12", or the version provided; empty if any version`),
+	extcommon.TextColumn(ColumnDescription, func(d dependency) string { return d.dep.Description }).
+		Describe("Reason for an optional dependency"),
+}
+
+// DependenciesStream generates row data for the "pacman_package_dependencies" table.
+func DependenciesStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	tbl := dependenciesTable.ForQuery(ctx, q)
+	return forEachPackage(ctx, func(pkg localPackage) error {
+		if m, err := dependenciesTable.Matches(q, dependency{pkg: pkg}, ColumnPackage); !m {
+			return err
+		}
+
+		for _, r := range relations {
+			// skip the relations the query doesn't select before listing them
+			if m, err := dependenciesTable.Matches(q, dependency{pkg: pkg, kind: r.kind}, ColumnKind); err != nil {
+				return err
+			} else if !m {
+				continue
+			}
+
+			for _, dep := range r.list(pkg) {
+				if more, err := tbl.Yield(q, dependency{pkg, r.kind, dep}, yield); !more {
+					return stopIteration(err)
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,218 @@
+package pacman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jguer/go-alpm/v2"
+	"go.fuhry.dev/osquery/extcommon"
+	"golang.org/x/sync/semaphore"
+)
+
+// lockPollInterval is how often to check whether a pacman transaction has finished.
+const lockPollInterval = 250 * time.Millisecond
+
+// maxLockWait is how long to wait for a pacman transaction to finish, regardless of the query's
+// timeout, so that a lock file left behind by a crashed pacman doesn't block queries forever.
+var maxLockWait = 5 * time.Second
+
+// errDatabaseChanged is returned by queries during which pacman modified the local database, so
+// that their results, which may mix the old and new states, aren't returned or cached.
+var errDatabaseChanged = errors.New("pacman database changed during query")
+
+// alpmHandle is an alpm handle shared by queries for as long as the local database and pacman.conf
+// don't change. Handles are reference-counted, so that a handle replaced because the database
+// changed is only released once the last query using it finishes.
+type alpmHandle struct {
+	*alpm.Handle
+	settings
+	syncDBs alpm.IDBList
+	stamp   string
+	refs    int
+	stale   bool
+}
+
+var (
+	h   *alpmHandle
+	hMu sync.Mutex
+)
+
+// alpmLock serializes reading package data through alpm handles, which libalpm loads lazily and
+// isn't safe for concurrent use. The tables also share a concurrency group, but the lock covers
+// every caller of the stream functions, and lets long queries release it while they don't use
+// libalpm.
+var alpmLock = semaphore.NewWeighted(1)
+
+// lockAlpm waits until no other query is reading package data, or until ctx is done. Every
+// successful call must be paired with a call to unlockAlpm.
+func lockAlpm(ctx context.Context) error {
+	if err := alpmLock.Acquire(ctx, 1); err != nil {
+		return fmt.Errorf("waiting for other pacman queries to finish: %w", err)
+	}
+	return nil
+}
+
+func unlockAlpm() {
+	alpmLock.Release(1)
+}
+
+// dbStamp returns a value which changes whenever a package is installed, upgraded or removed, the
+// sync databases are refreshed, a transaction starts or finishes, or pacman.conf changes.
+func dbStamp(s settings) (string, error) {
+	return extcommon.FileStamp(append([]string{path.Join(s.dbPath, "local"), s.syncDBPath(), s.lockPath()},
+		s.conf.files...)...)
+}
+
+// waitForTransaction waits until no pacman transaction is in progress, i.e. until pacman removes
+// its lock file, so that the database isn't read while it's being written. It gives up after
+// maxLockWait.
+func waitForTransaction(ctx context.Context, s settings) error {
+	ctx, cancel := context.WithTimeout(ctx, maxLockWait)
+	defer cancel()
+
+	logged := false
+	for {
+		_, err := os.Stat(s.lockPath())
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !logged {
+			extcommon.Logger(ctx).Debug("waiting for pacman transaction to finish", "lock", s.lockPath())
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for pacman transaction to finish (remove %s if pacman isn't running): %w",
+				s.lockPath(), ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// handle returns an alpm handle reflecting the current state of the local database, reusing the
+// handle of previous queries unless the database or pacman.conf has changed since it was
+// initialized. Every successful call must be paired with a call to release.
+func handle(ctx context.Context) (*alpmHandle, error) {
+	s := currentSettings()
+	if s.err != nil {
+		extcommon.Logger(ctx).Warn("failed to read pacman config, using defaults", "path", configPath, "error", s.err)
+	}
+	if err := waitForTransaction(ctx, s); err != nil {
+		return nil, err
+	}
+	stamp, err := dbStamp(s)
+	if err != nil {
+		return nil, err
+	}
+
+	hMu.Lock()
+	defer hMu.Unlock()
+
+	if h != nil && h.stamp != stamp {
+		extcommon.Logger(ctx).Debug("pacman database changed, refreshing alpm handle")
+		h.stale = true
+		if h.refs == 0 {
+			h.Release()
+		}
+		h = nil
+	}
+	if h == nil {
+		ah, err := alpm.Initialize(s.rootDir, s.dbPath)
+		if err != nil {
+			return nil, err
+		}
+		nh := &alpmHandle{Handle: ah, settings: s, stamp: stamp}
+		if err := nh.configure(ctx); err != nil {
+			ah.Release()
+			return nil, err
+		}
+		h = nh
+		extcommon.Logger(ctx).Debug("initialized alpm", "root", s.rootDir, "db_path", s.dbPath)
+	}
+	h.refs++
+	return h, nil
+}
+
+// configure applies the options of pacman.conf to the handle and registers its sync databases.
+func (ah *alpmHandle) configure(ctx context.Context) error {
+	conf := ah.conf
+	if dirs := conf.listOption("CacheDir"); len(dirs) > 0 {
+		if err := ah.SetCacheDirs(dirs); err != nil {
+			return err
+		}
+	}
+	if archs := conf.architectures(); len(archs) > 0 {
+		if err := ah.SetArchitectures(archs); err != nil {
+			return err
+		}
+	}
+	if err := ah.SetIgnorePkgs(conf.listOption("IgnorePkg")); err != nil {
+		return err
+	}
+	if err := ah.SetIgnoreGroups(conf.listOption("IgnoreGroup")); err != nil {
+		return err
+	}
+
+	var err error
+	ah.syncDBs, err = registerSyncDBs(ctx, ah.Handle, ah.settings)
+	return err
+}
+
+// registerSyncDBs registers the sync databases downloaded by pacman, in the order of pacman.conf,
+// or in lexical order if pacman.conf has no repositories. Their signatures aren't checked, since
+// they're only read.
+func registerSyncDBs(ctx context.Context, ah *alpm.Handle, s settings) (alpm.IDBList, error) {
+	repos := s.conf.repos
+	if len(repos) == 0 {
+		entries, err := os.ReadDir(s.syncDBPath())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			if name, ok := strings.CutSuffix(e.Name(), ".db"); ok && !e.IsDir() {
+				repos = append(repos, &repository{name: name})
+			}
+		}
+	}
+
+	for _, r := range repos {
+		// repositories which were never synced have no database to read
+		if _, err := os.Stat(path.Join(s.syncDBPath(), r.name+".db")); err != nil {
+			continue
+		}
+		db, err := ah.RegisterSyncDB(r.name, 0)
+		if err != nil {
+			extcommon.Logger(ctx).Warn("failed to register pacman sync database", "name", r.name, "error", err)
+			continue
+		}
+		db.SetUsage(repositoryUsage(r))
+	}
+	return ah.SyncDBs()
+}
+
+// release marks the end of a query's use of the handle. err is the query's error, which is
+// replaced with errDatabaseChanged if pacman modified the database while the query was running.
+func (ah *alpmHandle) release(err error) error {
+	hMu.Lock()
+	defer hMu.Unlock()
+
+	if ah.refs--; ah.refs == 0 && ah.stale {
+		ah.Release()
+	}
+
+	if err == nil {
+		if stamp, serr := dbStamp(ah.settings); serr != nil || stamp != ah.stamp {
+			return errDatabaseChanged
+		}
+	}
+	return err
+}
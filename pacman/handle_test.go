@@ -0,0 +1,44 @@
+package pacman
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForTransaction(t *testing.T) {
+	defer func(d time.Duration) { maxLockWait = d }(maxLockWait)
+	maxLockWait = 4 * lockPollInterval
+
+	s := settings{dbPath: t.TempDir()}
+	assert.NoError(t, waitForTransaction(context.Background(), s))
+
+	// a lock file left behind by a crashed pacman
+	assert.NoError(t, os.WriteFile(s.lockPath(), nil, 0o644))
+	start := time.Now()
+	err := waitForTransaction(context.Background(), s)
+	assert.ErrorContains(t, err, "remove "+s.lockPath()+" if pacman isn't running")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), maxLockWait+time.Second)
+
+	// a transaction which finishes while waiting
+	go func() {
+		time.Sleep(2 * lockPollInterval)
+		os.Remove(s.lockPath())
+	}()
+	assert.NoError(t, waitForTransaction(context.Background(), s))
+}
+
+func TestLockAlpm(t *testing.T) {
+	assert.NoError(t, lockAlpm(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, lockAlpm(ctx), context.DeadlineExceeded)
+	unlockAlpm()
+
+	assert.NoError(t, lockAlpm(context.Background()))
+	unlockAlpm()
+}
@@ -0,0 +1,131 @@
+package pacman
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/Jguer/go-alpm/v2"
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+const (
+	ColumnType       = "type"
+	ColumnBackup     = "backup"
+	ColumnStatus     = "status"
+	ColumnMismatches = "mismatches"
+)
+
+// verifiedFile is a file of a package compared with its mtree entry.
+type verifiedFile struct {
+	pkg        string
+	entry      mtreeEntry
+	backup     bool
+	status     string
+	mismatches []string
+}
+
+var integrityTable = extcommon.Table[verifiedFile]{
+	extcommon.TextColumn(ColumnPackage, func(f verifiedFile) string { return f.pkg }).
+		Describe("Name of the package owning the file").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnPath, func(f verifiedFile) string { return f.entry.relPath() }).
+		Describe("Path of the file, relative to the root directory, as in pacman_files").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnType, func(f verifiedFile) string { return f.entry.keywords["type"] }).
+		Describe(`Type of the file when it was installed: "file", "dir" or "link"`),
+	extcommon.BoolColumn(ColumnBackup, func(f verifiedFile) bool { return f.backup }).
+		Describe("Set to 1 if the file is a backup file, whose contents, size and modification time aren't verified"),
+	extcommon.TextColumn(ColumnStatus, func(f verifiedFile) string { return f.status }).
+		Describe(`Result of the verification: "ok", "modified", "missing", "type_changed", or "unreadable" if the file couldn't be read`),
+	extcommon.TextColumn(ColumnMismatches, func(f verifiedFile) string { return strings.Join(f.mismatches, ",") }).
+		Describe(`Comma-separated list of the attributes of a modified file which differ from when it was installed: "mode", "uid", "gid", "link", "time", "size" or "sha256"`),
+}
+
+// integrityPackage is the data of a package needed to verify its files, which is read from libalpm
+// up front so that the files are verified without holding alpmLock.
+type integrityPackage struct {
+	name, mtree string
+	backup      map[string]bool
+}
+
+// integrityPackages returns the packages whose files a pacman_file_integrity query verifies.
+func integrityPackages(ctx context.Context, h *alpmHandle, q table.QueryContext) (out []integrityPackage, err error) {
+	if err := lockAlpm(ctx); err != nil {
+		return nil, err
+	}
+	defer unlockAlpm()
+
+	db, err := h.LocalDB()
+	if err != nil {
+		return nil, err
+	}
+	err = db.PkgCache().ForEach(func(p alpm.IPackage) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkg := localPackage{p, h}
+		if m, err := integrityTable.Matches(q, verifiedFile{pkg: pkg.Name()}, ColumnPackage); !m {
+			return err
+		}
+
+		ip := integrityPackage{name: pkg.Name(), mtree: mtreePath(pkg), backup: map[string]bool{}}
+		_ = pkg.Backup().ForEach(func(b alpm.BackupFile) error {
+			ip.backup[b.Name] = true
+			return nil
+		})
+		out = append(out, ip)
+		return nil
+	})
+	return out, err
+}
+
+// IntegrityStream generates row data for the "pacman_file_integrity" table. Unlike the other
+// tables, it only holds alpmLock while listing the packages, since verifying their files may take
+// a long time.
+func IntegrityStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) (err error) {
+	h, err := handle(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = h.release(err) }()
+
+	pkgs, err := integrityPackages(ctx, h, q)
+	if err != nil {
+		return err
+	}
+
+	tbl := integrityTable.ForQuery(ctx, q)
+	for _, pkg := range pkgs {
+		entries, err := readMtree(pkg.mtree)
+		if errors.Is(err, fs.ErrNotExist) {
+			// packages installed before pacman 4.1 have no mtree file
+			extcommon.Logger(ctx).Debug("package has no mtree file", "package", pkg.name)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			f := verifiedFile{pkg: pkg.name, entry: e, backup: pkg.backup[e.path]}
+			// filter on the columns known from the mtree file before reading the file, which
+			// may mean hashing it
+			if m, err := integrityTable.Matches(q, f, ColumnPath, ColumnType, ColumnBackup); err != nil {
+				return err
+			} else if !m {
+				continue
+			}
+
+			f.status, f.mismatches = e.verify(h.rootDir, f.backup)
+			if more, err := tbl.Yield(q, f, yield); !more {
+				return err
+			}
+		}
+	}
+	return nil
+}
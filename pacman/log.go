@@ -0,0 +1,269 @@
+package pacman
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Jguer/go-alpm/v2"
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+const (
+	ColumnTimestamp  = "timestamp"
+	ColumnSource     = "source"
+	ColumnAction     = "action"
+	ColumnOldVersion = "old_version"
+	ColumnNewVersion = "new_version"
+	ColumnMessage    = "message"
+)
+
+// logTimeLayouts are the formats of the timestamps of pacman.log. pacman 5.1 and later log the
+// time with seconds and the UTC offset; earlier versions logged the local time to the minute.
+var logTimeLayouts = []string{"2006-01-02T15:04:05-0700", "2006-01-02 15:04"}
+
+// logActionRe matches the messages logged by libalpm for each package of a transaction, e.g.
+// "upgraded linux (6.6.1.arch1-1 -> 6.6.2.arch1-1)".
+var logActionRe = regexp.MustCompile(`^(installed|upgraded|downgraded|removed|reinstalled) (\S+) \((.*)\)$`)
+
+// logEntry is a line of pacman.log.
+type logEntry struct {
+	time                                time.Time
+	source, message                     string
+	action, pkg, oldVersion, newVersion string
+}
+
+var logTable = extcommon.Table[logEntry]{
+	extcommon.BigIntColumn(ColumnTimestamp, func(e logEntry) int64 { return e.time.Unix() }).
+		Describe("Time of the entry, in seconds since the Unix epoch").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnSource, func(e logEntry) string { return e.source }).
+		Describe(`Component which logged the entry: "PACMAN", "ALPM", "ALPM-SCRIPTLET", or another tool using libalpm`),
+	extcommon.TextColumn(ColumnAction, func(e logEntry) string { return e.action }).
+		Describe(`Action on a package logged by libalpm: "installed", "upgraded", "downgraded", "removed" or "reinstalled"; empty for other entries`),
+	extcommon.TextColumn(ColumnPackage, func(e logEntry) string { return e.pkg }).
+		Describe("Name of the package the action applies to"),
+	extcommon.TextColumn(ColumnOldVersion, func(e logEntry) string { return e.oldVersion }).
+		CompareWith(alpm.VerCmp).
+		Describe("Version of the package before the action; empty if it wasn't installed"),
+	extcommon.TextColumn(ColumnNewVersion, func(e logEntry) string { return e.newVersion }).
+		CompareWith(alpm.VerCmp).
+		Describe("Version of the package after the action; empty if it was removed"),
+	extcommon.TextColumn(ColumnMessage, func(e logEntry) string { return e.message }).
+		Describe("Message of the entry"),
+}
+
+// parseLogLine parses a line of pacman.log, e.g.
+// "[2024-01-15T10:23:50+0100] [ALPM] upgraded linux (6.6.1.arch1-1 -> 6.6.2.arch1-1)". ok is
+// false for lines which aren't entries, such as the continuation lines of scriptlet output.
+func parseLogLine(line string) (e logEntry, ok bool) {
+	ts, rest, ok := cutBracketed(line)
+	if !ok {
+		return e, false
+	}
+	for _, layout := range logTimeLayouts {
+		var err error
+		if e.time, err = time.ParseInLocation(layout, ts, time.Local); err == nil {
+			break
+		}
+	}
+	if e.time.IsZero() {
+		return e, false
+	}
+
+	if e.source, rest, ok = cutBracketed(strings.TrimPrefix(rest, " ")); !ok {
+		// very old versions of pacman didn't log the source
+		e.source, rest = "", line[len(ts)+2:]
+	}
+	e.message = strings.TrimPrefix(rest, " ")
+
+	if e.source == "ALPM" || e.source == "" {
+		if m := logActionRe.FindStringSubmatch(e.message); m != nil {
+			e.action, e.pkg = m[1], m[2]
+			switch from, to, found := strings.Cut(m[3], " -> "); {
+			case found:
+				e.oldVersion, e.newVersion = from, to
+			case e.action == "removed":
+				e.oldVersion = from
+			case e.action == "reinstalled":
+				e.oldVersion, e.newVersion = from, from
+			default:
+				e.newVersion = from
+			}
+		}
+	}
+	return e, true
+}
+
+// cutBracketed returns the contents of the brackets s starts with, and the rest of s.
+func cutBracketed(s string) (inner, rest string, ok bool) {
+	if !strings.HasPrefix(s, "[") {
+		return "", s, false
+	}
+	inner, rest, ok = strings.Cut(s[1:], "]")
+	if !ok {
+		return "", s, false
+	}
+	return inner, rest, true
+}
+
+// logFiles returns the paths of the log file and of its rotated copies, e.g. "pacman.log.1",
+// "pacman.log.2.gz" or "pacman.log-20240101.gz", from the oldest to the newest.
+func logFiles(logPath string) ([]string, error) {
+	var rotated []string
+	for _, pattern := range []string{logPath + ".*", logPath + "-*"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rotated = append(rotated, matches...)
+	}
+
+	type file struct {
+		path  string
+		mtime time.Time
+	}
+	var files []file
+	for _, p := range append(rotated, logPath) {
+		fi, err := os.Stat(p)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, file{p, fi.ModTime()})
+	}
+	slices.SortStableFunc(files, func(a, b file) int { return a.mtime.Compare(b.mtime) })
+
+	out := make([]string, 0, len(files))
+	for _, f := range files {
+		out = append(out, f.path)
+	}
+	return out, nil
+}
+
+// maxLogLine is the length after which lines of the log are truncated, e.g. output of a scriptlet
+// which printed a long line.
+const maxLogLine = 1024 * 1024
+
+// openLog opens a log file, decompressing it if it's compressed with gzip or bzip2. ok is false
+// for files compressed with formats which aren't supported.
+func openLog(p string) (r io.ReadCloser, ok bool, err error) {
+	var decompress func(io.Reader) (io.Reader, error)
+	switch filepath.Ext(p) {
+	case ".gz":
+		decompress = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case ".bz2":
+		decompress = func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }
+	case ".xz", ".zst", ".lz4", ".lzma":
+		return nil, false, nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil || decompress == nil {
+		return f, true, err
+	}
+	zr, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, true, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, true, nil
+}
+
+// readLogLine reads a line of a log file without its line ending, truncated to maxLogLine bytes.
+func readLogLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, more, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if n := min(len(chunk), maxLogLine-len(line)); n > 0 {
+			line = append(line, chunk[:n]...)
+		}
+		if !more {
+			return string(line), nil
+		}
+	}
+}
+
+// LogStream generates row data for the "pacman_log" table.
+func LogStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	files, err := logFiles(currentSettings().logFile)
+	if err != nil {
+		return err
+	}
+	after, bounded := extcommon.LowerBound(q, ColumnTimestamp)
+
+	tbl := logTable.ForQuery(ctx, q)
+	for _, p := range files {
+		if bounded {
+			// files which were last written before the query's time range only have older entries
+			if fi, err := os.Stat(p); err == nil && fi.ModTime().Unix() < after {
+				continue
+			}
+		}
+		more, err := streamLog(ctx, q, p, tbl, yield)
+		if errors.Is(err, fs.ErrNotExist) {
+			// logrotate removed or renamed the file after it was listed
+			extcommon.Logger(ctx).Debug("pacman log disappeared", "path", p)
+			continue
+		}
+		if !more || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamLog yields the entries of a log file. It returns false once no more rows are needed.
+func streamLog(ctx context.Context, q table.QueryContext, p string, tbl extcommon.Table[logEntry], yield extcommon.YieldFunc) (bool, error) {
+	f, ok, err := openLog(p)
+	if !ok {
+		extcommon.Logger(ctx).Warn("skipping pacman log compressed with an unsupported format", "path", p)
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := readLogLine(r)
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		e, ok := parseLogLine(line)
+		if !ok {
+			continue
+		}
+		if more, err := tbl.Yield(q, e, yield); !more {
+			return false, err
+		}
+	}
+}
+
+// LogCacheStamp returns a cache stamp which changes whenever pacman writes to its log, or the log
+// is rotated.
+func LogCacheStamp(table.QueryContext) (string, error) {
+	logFile := currentSettings().logFile
+	return extcommon.FileStamp(logFile, filepath.Dir(logFile))
+}
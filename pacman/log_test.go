@@ -0,0 +1,128 @@
+package pacman
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLine(t *testing.T) {
+	type testCase struct {
+		line   string
+		ok     bool
+		expect logEntry
+	}
+
+	ts := time.Date(2024, 1, 15, 9, 23, 50, 0, time.UTC)
+	oldTS := time.Date(2012, 3, 4, 5, 6, 0, 0, time.Local)
+
+	var testCases = []*testCase{
+		{"[2024-01-15T10:23:50+0100] [ALPM] upgraded linux (6.6.1.arch1-1 -> 6.6.2.arch1-1)", true, logEntry{
+			time: ts, source: "ALPM", message: "upgraded linux (6.6.1.arch1-1 -> 6.6.2.arch1-1)",
+			action: "upgraded", pkg: "linux", oldVersion: "6.6.1.arch1-1", newVersion: "6.6.2.arch1-1",
+		}},
+		{"[2024-01-15T10:23:50+0100] [ALPM] installed foo (1.0-1)", true, logEntry{
+			time: ts, source: "ALPM", message: "installed foo (1.0-1)",
+			action: "installed", pkg: "foo", newVersion: "1.0-1",
+		}},
+		{"[2024-01-15T10:23:50+0100] [ALPM] removed foo (1.0-1)", true, logEntry{
+			time: ts, source: "ALPM", message: "removed foo (1.0-1)",
+			action: "removed", pkg: "foo", oldVersion: "1.0-1",
+		}},
+		{"[2024-01-15T10:23:50+0100] [ALPM] reinstalled foo (1.0-1)", true, logEntry{
+			time: ts, source: "ALPM", message: "reinstalled foo (1.0-1)",
+			action: "reinstalled", pkg: "foo", oldVersion: "1.0-1", newVersion: "1.0-1",
+		}},
+		{"[2024-01-15T10:23:50+0100] [PACMAN] Running 'pacman -Syu'", true, logEntry{
+			time: ts, source: "PACMAN", message: "Running 'pacman -Syu'",
+		}},
+		{"[2024-01-15T10:23:50+0100] [ALPM-SCRIPTLET] installed foo (1.0-1)", true, logEntry{
+			time: ts, source: "ALPM-SCRIPTLET", message: "installed foo (1.0-1)",
+		}},
+		{"[2012-03-04 05:06] upgraded bar (1:2.0-1 -> 1:2.1-1)", true, logEntry{
+			time: oldTS, message: "upgraded bar (1:2.0-1 -> 1:2.1-1)",
+			action: "upgraded", pkg: "bar", oldVersion: "1:2.0-1", newVersion: "1:2.1-1",
+		}},
+		{"  continued scriptlet output", false, logEntry{}},
+		{"[not a time] [ALPM] installed foo (1.0-1)", false, logEntry{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.line, func(t *testing.T) {
+			e, ok := parseLogLine(tc.line)
+			assert.Equal(t, tc.ok, ok)
+			if !ok {
+				return
+			}
+			assert.True(t, tc.expect.time.Equal(e.time), "%v != %v", tc.expect.time, e.time)
+			e.time = tc.expect.time
+			assert.Equal(t, tc.expect, e)
+		})
+	}
+}
+
+func TestLogStream(t *testing.T) {
+	dir := t.TempDir()
+	defer func(p string) { logPath = p }(logPath)
+	logPath = path.Join(dir, "pacman.log")
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write([]byte("[2024-01-01T00:00:00+0000] [ALPM] installed foo (1.0-1)\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	// compressed by bzip2 from "[2023-12-01T00:00:00+0000] [ALPM] installed bar (1.0-1)\n"
+	bz2 := "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x1f\xdc\x8b\xbd\x00\x00\x14\xdf\x80\x40\x10\x40\x6b\x78\x10\x20\x06\x44" +
+		"\x0a\x36\x25\x1c\x00\x20\x00\x50\xa0\x00\x00\x00\x04\x53\xf2\xa3\xd2\x68\x30\x87\xa4\x0d\x34\x7a\xd5\xba\x18\xea\xe8" +
+		"\x08\xde\x52\x22\x42\x25\xa6\xf5\x43\x30\x5f\x42\xa1\x0e\xf4\x67\x34\x9a\x96\xef\x0f\x2a\x47\xb5\x1f\x5b\xf1\x77\x24" +
+		"\x53\x85\x09\x01\xfd\xc8\xbb\xd0"
+	// lines longer than maxLogLine are truncated rather than failing the query
+	long := "[2024-01-15T00:00:00+0000] [ALPM-SCRIPTLET] " + strings.Repeat("x", 2*maxLogLine) + "\n"
+
+	files := []struct {
+		name, contents string
+		mtime          time.Time
+	}{
+		{"pacman.log.4.xz", "unsupported", time.Unix(1698796800, 0)},
+		{"pacman.log.3.bz2", bz2, time.Unix(1701388800, 0)},
+		{"pacman.log.2.gz", gz.String(), time.Unix(1704067200, 0)},
+		{"pacman.log.1", long + "[2024-02-01T00:00:00+0000] [ALPM] upgraded foo (1.0-1 -> 1.1-1)\n", time.Unix(1706745600, 0)},
+		{"pacman.log", "[2024-03-01T00:00:00+0000] [ALPM] removed foo (1.1-1)\n", time.Unix(1709251200, 0)},
+	}
+	for _, f := range files {
+		p := path.Join(dir, f.name)
+		assert.NoError(t, os.WriteFile(p, []byte(f.contents), 0o644))
+		assert.NoError(t, os.Chtimes(p, f.mtime, f.mtime))
+	}
+
+	var messages []string
+	generate := func(q table.QueryContext) (actions []string) {
+		messages = nil
+		err := LogStream(context.Background(), q, func(row map[string]string) bool {
+			actions = append(actions, row[ColumnAction])
+			messages = append(messages, row[ColumnMessage])
+			return true
+		})
+		assert.NoError(t, err)
+		return
+	}
+
+	assert.Equal(t, []string{"installed", "installed", "", "upgraded", "removed"}, generate(table.QueryContext{}))
+	assert.Len(t, messages[2], maxLogLine-len("[2024-01-15T00:00:00+0000] [ALPM-SCRIPTLET] "))
+	assert.Equal(t, []string{"", "upgraded", "removed"}, generate(table.QueryContext{
+		Constraints: map[string]table.ConstraintList{
+			ColumnTimestamp: {Constraints: []table.Constraint{
+				{Operator: table.OperatorGreaterThan, Expression: "1704067200"},
+			}},
+		},
+	}))
+}
@@ -0,0 +1,263 @@
+package pacman
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Types of mtree entries.
+const (
+	mtreeFile = "file"
+	mtreeDir  = "dir"
+	mtreeLink = "link"
+)
+
+// Statuses of the files of the "pacman_file_integrity" table.
+const (
+	StatusOK          = "ok"
+	StatusModified    = "modified"
+	StatusMissing     = "missing"
+	StatusTypeChanged = "type_changed"
+	StatusUnreadable  = "unreadable"
+)
+
+// mtreeEntry describes a file as it was installed by a package. Its keywords are those of the
+// mtree(5) format, e.g. "type", "mode", "uid", "gid", "time", "size", "link" and "sha256digest".
+type mtreeEntry struct {
+	path     string
+	keywords map[string]string
+}
+
+// mtreePath returns the path of the mtree file of a package in the local database, which libalpm
+// writes when the package is installed.
+func mtreePath(pkg localPackage) string {
+	return path.Join(pkg.h.dbPath, "local", pkg.Name()+"-"+pkg.Version(), "mtree")
+}
+
+// readMtree reads the gzip-compressed mtree file of a package.
+func readMtree(p string) ([]mtreeEntry, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.Name(), err)
+	}
+	defer zr.Close()
+
+	entries, err := parseMtree(zr)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.Name(), err)
+	}
+	return entries, nil
+}
+
+// parseMtree parses the entries of an mtree file, applying the defaults of its /set and /unset
+// lines. The metadata files of the package, such as .PKGINFO, are skipped, as pacman does.
+func parseMtree(r io.Reader) (out []mtreeEntry, err error) {
+	defaults := map[string]string{}
+	s := bufio.NewScanner(r)
+	var line string
+	for s.Scan() {
+		// long lines may be continued with a trailing backslash
+		if l, ok := strings.CutSuffix(s.Text(), `\`); ok {
+			line += l + " "
+			continue
+		}
+		line += s.Text()
+		fields := strings.Fields(line)
+		line = ""
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "/set":
+			for _, kv := range fields[1:] {
+				k, v, _ := strings.Cut(kv, "=")
+				defaults[k] = v
+			}
+			continue
+		case "/unset":
+			for _, k := range fields[1:] {
+				delete(defaults, k)
+			}
+			continue
+		}
+
+		p, err := unescapeMtreePath(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		p = strings.TrimPrefix(p, "./")
+		if strings.HasPrefix(p, ".") {
+			continue
+		}
+
+		e := mtreeEntry{path: p, keywords: make(map[string]string, len(defaults)+len(fields)-1)}
+		for k, v := range defaults {
+			e.keywords[k] = v
+		}
+		for _, kv := range fields[1:] {
+			k, v, _ := strings.Cut(kv, "=")
+			e.keywords[k] = v
+		}
+		if l, ok := e.keywords["link"]; ok {
+			if e.keywords["link"], err = unescapeMtreePath(l); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, e)
+	}
+	return out, s.Err()
+}
+
+// unescapeMtreePath decodes the octal escapes, e.g. "\040" for a space, of a path in an mtree file.
+func unescapeMtreePath(p string) (string, error) {
+	if !strings.Contains(p, `\`) {
+		return p, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] != '\\' {
+			b.WriteByte(p[i])
+			continue
+		}
+		if i+4 > len(p) {
+			return "", fmt.Errorf("invalid escape in mtree path %q", p)
+		}
+		c, err := strconv.ParseUint(p[i+1:i+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in mtree path %q", p)
+		}
+		b.WriteByte(byte(c))
+		i += 3
+	}
+	return b.String(), nil
+}
+
+// relPath returns the path of the entry in the format of the "pacman_files" table, i.e. relative
+// to the root directory and with a trailing slash for directories.
+func (e mtreeEntry) relPath() string {
+	if e.keywords["type"] == mtreeDir {
+		return e.path + "/"
+	}
+	return e.path
+}
+
+// verify compares the file described by the entry with the file at the same path under root, like
+// pacman -Qkk. It returns the status of the file and the keywords which don't match. As with pacman,
+// the contents, size and modification time of backup files, which users are expected to edit, aren't
+// compared.
+func (e mtreeEntry) verify(root string, backup bool) (status string, mismatches []string) {
+	name := path.Join(root, e.path)
+	fi, err := os.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return StatusMissing, nil
+	} else if err != nil {
+		return StatusUnreadable, nil
+	}
+
+	typ := e.keywords["type"]
+	switch {
+	case typ == mtreeDir && !fi.IsDir(),
+		typ == mtreeLink && fi.Mode().Type() != fs.ModeSymlink,
+		typ == mtreeFile && !fi.Mode().IsRegular():
+		return StatusTypeChanged, nil
+	}
+
+	if v, ok := e.keywords["mode"]; ok && typ != mtreeLink {
+		if mode, err := strconv.ParseUint(v, 8, 32); err != nil || uint32(mode) != unixMode(fi.Mode()) {
+			mismatches = append(mismatches, "mode")
+		}
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if v, ok := e.keywords["uid"]; ok && v != strconv.FormatUint(uint64(st.Uid), 10) {
+			mismatches = append(mismatches, "uid")
+		}
+		if v, ok := e.keywords["gid"]; ok && v != strconv.FormatUint(uint64(st.Gid), 10) {
+			mismatches = append(mismatches, "gid")
+		}
+	}
+	if typ == mtreeLink {
+		if target, err := os.Readlink(name); err != nil || target != e.keywords["link"] {
+			mismatches = append(mismatches, "link")
+		}
+	}
+
+	if typ != mtreeDir && !backup {
+		if v, ok := e.keywords["time"]; ok {
+			sec, _, _ := strings.Cut(v, ".")
+			if sec != strconv.FormatInt(fi.ModTime().Unix(), 10) {
+				mismatches = append(mismatches, "time")
+			}
+		}
+	}
+	if typ == mtreeFile && !backup {
+		sizeOK := true
+		if v, ok := e.keywords["size"]; ok && v != strconv.FormatInt(fi.Size(), 10) {
+			mismatches = append(mismatches, "size")
+			sizeOK = false
+		}
+		// files of a different size can't have the same contents, so skip hashing them
+		if v, ok := e.keywords["sha256digest"]; ok && sizeOK {
+			sum, err := hashFile(name, sha256.New())
+			if err != nil {
+				return StatusUnreadable, mismatches
+			}
+			if sum != v {
+				mismatches = append(mismatches, "sha256")
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return StatusModified, mismatches
+	}
+	return StatusOK, nil
+}
+
+// unixMode returns the permission bits of a file mode, including the setuid, setgid and sticky
+// bits, as in a Unix mode.
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= syscall.S_ISUID
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= syscall.S_ISGID
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= syscall.S_ISVTX
+	}
+	return mode
+}
+
+// hashFile returns the hex-encoded digest of a file's contents.
+func hashFile(name string, h hash.Hash) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
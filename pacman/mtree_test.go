@@ -0,0 +1,113 @@
+package pacman
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMtree = `#mtree
+/set type=file uid=0 gid=0 mode=644
+./.BUILDINFO time=1700000000.0 size=4795 md5digest=0 sha256digest=0
+./.PKGINFO time=1700000000.0 size=678 md5digest=0 sha256digest=0
+./usr time=1700000000.0 mode=755 type=dir
+./usr/bin time=1700000000.0 mode=755 type=dir
+./usr/bin/sudo time=1700000000.0 mode=4111 size=232488 \
+    sha256digest=6f1b0a4e
+/set mode=777
+./usr/lib/libfoo.so time=1700000000.0 type=link link=libfoo.so.1
+/unset mode
+./usr/share/with\040space time=1700000000.0 size=0 sha256digest=e3b0c442
+`
+
+func TestParseMtree(t *testing.T) {
+	entries, err := parseMtree(strings.NewReader(testMtree))
+	assert.NoError(t, err)
+
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.relPath())
+	}
+	assert.Equal(t, []string{"usr/", "usr/bin/", "usr/bin/sudo", "usr/lib/libfoo.so", "usr/share/with space"}, paths)
+
+	sudo := entries[2].keywords
+	assert.Equal(t, "file", sudo["type"])
+	assert.Equal(t, "4111", sudo["mode"])
+	assert.Equal(t, "0", sudo["uid"])
+	assert.Equal(t, "6f1b0a4e", sudo["sha256digest"])
+
+	assert.Equal(t, "777", entries[3].keywords["mode"])
+	assert.Equal(t, "libfoo.so.1", entries[3].keywords["link"])
+	_, ok := entries[4].keywords["mode"]
+	assert.False(t, ok)
+
+	_, err = parseMtree(strings.NewReader("./usr/bad\\04 type=file\n"))
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	contents := []byte("#!/bin/sh\n")
+	sum := sha256.Sum256(contents)
+
+	assert.NoError(t, os.MkdirAll(path.Join(root, "usr/bin"), 0o755))
+	assert.NoError(t, os.WriteFile(path.Join(root, "usr/bin/tool"), contents, 0o755))
+	assert.NoError(t, os.Chtimes(path.Join(root, "usr/bin/tool"), mtime, mtime))
+	assert.NoError(t, os.Symlink("tool", path.Join(root, "usr/bin/link")))
+	fi, err := os.Lstat(path.Join(root, "usr/bin/link"))
+	assert.NoError(t, err)
+	linkTime := strconv.FormatInt(fi.ModTime().Unix(), 10)
+
+	file := func(p string, kv ...string) mtreeEntry {
+		e := mtreeEntry{path: p, keywords: map[string]string{
+			"type":         "file",
+			"mode":         "755",
+			"uid":          strconv.Itoa(os.Getuid()),
+			"gid":          strconv.Itoa(os.Getgid()),
+			"time":         "1700000000.123",
+			"size":         strconv.Itoa(len(contents)),
+			"sha256digest": hex.EncodeToString(sum[:]),
+		}}
+		for i := 0; i < len(kv); i += 2 {
+			e.keywords[kv[i]] = kv[i+1]
+		}
+		return e
+	}
+
+	testCases := []struct {
+		name       string
+		entry      mtreeEntry
+		backup     bool
+		status     string
+		mismatches []string
+	}{
+		{"ok", file("usr/bin/tool"), false, StatusOK, nil},
+		{"mode", file("usr/bin/tool", "mode", "4755"), false, StatusModified, []string{"mode"}},
+		{"owner", file("usr/bin/tool", "uid", "12345", "gid", "12345"), false, StatusModified, []string{"uid", "gid"}},
+		{"time", file("usr/bin/tool", "time", "1600000000.0"), false, StatusModified, []string{"time"}},
+		{"size", file("usr/bin/tool", "size", "1"), false, StatusModified, []string{"size"}},
+		{"contents", file("usr/bin/tool", "sha256digest", "00"), false, StatusModified, []string{"sha256"}},
+		{"backup", file("usr/bin/tool", "size", "1", "sha256digest", "00"), true, StatusOK, nil},
+		{"missing", file("usr/bin/gone"), false, StatusMissing, nil},
+		{"type changed", file("usr/bin", "type", "file"), false, StatusTypeChanged, nil},
+		{"dir", file("usr/bin", "type", "dir", "time", "0"), false, StatusOK, nil},
+		{"link", file("usr/bin/link", "type", "link", "link", "tool", "mode", "777", "time", linkTime), false, StatusOK, nil},
+		{"link target", file("usr/bin/link", "type", "link", "link", "other", "time", linkTime), false, StatusModified, []string{"link"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, mismatches := tc.entry.verify(root, tc.backup)
+			assert.Equal(t, tc.status, status)
+			assert.Equal(t, tc.mismatches, mismatches)
+		})
+	}
+}
@@ -2,13 +2,15 @@ package pacman
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"fmt"
+	"os"
+	"path"
 	"strings"
-	"sync"
 
 	"github.com/Jguer/go-alpm/v2"
 	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
 )
 
 const (
@@ -20,26 +22,126 @@ const (
 	ColumnLicense      = "license"
 	ColumnSize         = "size"
 	ColumnExplicit     = "explicit"
+	ColumnInstallDate  = "install_date"
+	ColumnBuildDate    = "build_date"
+	ColumnPackager     = "packager"
+	ColumnBase         = "base"
+	ColumnGroups       = "groups"
+	ColumnValidation   = "validation"
+	ColumnReason       = "reason"
+	ColumnRepository   = "repository"
+	ColumnDownloadSize = "download_size"
+	ColumnHasScriptlet = "has_scriptlet"
 
 	ColumnPackage = "package"
 	ColumnPath    = "path"
 )
 
-var (
-	h      *alpm.Handle
-	hMu    sync.Mutex
-	dbPath string = "/var/lib/pacman"
-)
+// Paths set by flags, which override those of pacman.conf.
+var rootDir, dbPath, logPath string
+
+// localPackage is a package of the local database.
+type localPackage struct {
+	alpm.IPackage
+	h *alpmHandle
+}
+
+// syncPackage returns the package of the same name in the sync databases, preferring the first
+// database with the installed version, or nil if the package isn't in any sync database.
+func (p localPackage) syncPackage() (pkg alpm.IPackage) {
+	_ = p.h.syncDBs.ForEach(func(db alpm.IDB) error {
+		sp := db.Pkg(p.Name())
+		if sp == nil {
+			return nil
+		}
+		if pkg == nil {
+			pkg = sp
+		}
+		if sp.Version() == p.Version() {
+			pkg = sp
+			return errStop
+		}
+		return nil
+	})
+	return
+}
+
+// repository returns the name of the sync database the package was presumably installed from.
+func (p localPackage) repository() string {
+	if sp := p.syncPackage(); sp != nil {
+		return sp.DB().Name()
+	}
+	return ""
+}
+
+// downloadSize returns the compressed size of the installed version of the package, which is only
+// known to the sync databases.
+func (p localPackage) downloadSize() int64 {
+	if sp := p.syncPackage(); sp != nil && sp.Version() == p.Version() {
+		return sp.Size()
+	}
+	return 0
+}
+
+// hasScriptlet reports whether the package has an install scriptlet. libalpm only keeps it in the
+// local database, as the package's "install" file.
+func (p localPackage) hasScriptlet() bool {
+	_, err := os.Stat(path.Join(p.h.dbPath, "local", p.Name()+"-"+p.Version(), "install"))
+	return err == nil
+}
+
+// validationNames are the names of the ways a package can have been validated, in the order
+// they're listed in the "validation" column.
+var validationNames = []struct {
+	v    alpm.Validation
+	name string
+}{
+	{alpm.ValidationNone, "none"},
+	{alpm.ValidationMD5Sum, "md5"},
+	{alpm.ValidationSHA256Sum, "sha256"},
+	{alpm.ValidationSignature, "signature"},
+}
+
+func validation(p localPackage) string {
+	var out []string
+	v := p.Validation()
+	for _, n := range validationNames {
+		if v&n.v != 0 {
+			out = append(out, n.name)
+		}
+	}
+	return strings.Join(out, ",")
+}
 
-var packagesColumns = []columnDef[alpm.IPackage]{
-	stringColumn[alpm.IPackage]{ColumnName, func(p alpm.IPackage) string { return p.Name() }},
-	stringColumn[alpm.IPackage]{ColumnVersion, func(p alpm.IPackage) string { return p.Version() }},
-	stringColumn[alpm.IPackage]{ColumnDescription, func(p alpm.IPackage) string { return p.Description() }},
-	stringColumn[alpm.IPackage]{ColumnArchitecture, func(p alpm.IPackage) string { return p.Architecture() }},
-	stringColumn[alpm.IPackage]{ColumnUrl, func(p alpm.IPackage) string { return p.URL() }},
-	stringColumn[alpm.IPackage]{ColumnLicense, func(p alpm.IPackage) string { return strings.Join(p.Licenses().Slice(), ",") }},
-	intColumn[alpm.IPackage]{ColumnSize, func(p alpm.IPackage) int64 { return p.ISize() }},
-	boolColumn[alpm.IPackage]{ColumnExplicit, func(p alpm.IPackage) bool { return p.Reason() == alpm.PkgReasonExplicit }},
+func reason(p localPackage) string {
+	switch p.Reason() {
+	case alpm.PkgReasonExplicit:
+		return "explicit"
+	case alpm.PkgReasonDepend:
+		return "dependency"
+	}
+	return ""
+}
+
+var packagesTable = extcommon.Table[localPackage]{
+	extcommon.TextColumn(ColumnName, localPackage.Name).Describe("Name of the package"),
+	extcommon.TextColumn(ColumnVersion, localPackage.Version).CompareWith(alpm.VerCmp).Describe("Version of the package. Comparisons use pacman's version ordering"),
+	extcommon.TextColumn(ColumnDescription, localPackage.Description).Describe("Description of the package"),
+	extcommon.TextColumn(ColumnArchitecture, localPackage.Architecture).Describe("Architecture the package was built for"),
+	extcommon.TextColumn(ColumnUrl, localPackage.URL).Describe("URL of the upstream project"),
+	extcommon.TextColumn(ColumnLicense, func(p localPackage) string { return strings.Join(p.Licenses().Slice(), ",") }).Describe("Comma-separated list of licenses of the package"),
+	extcommon.BigIntColumn(ColumnSize, localPackage.ISize).Describe("Installed size of the package, in bytes"),
+	extcommon.BoolColumn(ColumnExplicit, func(p localPackage) bool { return p.Reason() == alpm.PkgReasonExplicit }).Describe("Set to 1 if the package was explicitly installed, 0 if it was installed as a dependency"),
+	extcommon.BigIntColumn(ColumnInstallDate, func(p localPackage) int64 { return p.InstallDate().Unix() }).Describe("Time the package was installed or last upgraded, in seconds since the Unix epoch"),
+	extcommon.BigIntColumn(ColumnBuildDate, func(p localPackage) int64 { return p.BuildDate().Unix() }).Describe("Time the package was built, in seconds since the Unix epoch"),
+	extcommon.TextColumn(ColumnPackager, localPackage.Packager).Describe("Name and email address of the packager"),
+	extcommon.TextColumn(ColumnBase, localPackage.Base).Describe("Name of the package base the package was built from"),
+	extcommon.TextColumn(ColumnGroups, func(p localPackage) string { return strings.Join(p.Groups().Slice(), ",") }).Describe("Comma-separated list of groups the package belongs to"),
+	extcommon.TextColumn(ColumnValidation, validation).Describe(`Comma-separated list of the ways the package was validated when it was installed: "none", "md5", "sha256" or "signature"`),
+	extcommon.TextColumn(ColumnReason, reason).Describe(`Reason the package was installed: "explicit" or "dependency"`),
+	extcommon.TextColumn(ColumnRepository, localPackage.repository).Describe("Name of the first sync database with the installed version of the package, or else with any version of it; empty for packages which aren't in any sync database"),
+	extcommon.BigIntColumn(ColumnDownloadSize, localPackage.downloadSize).Describe("Compressed size of the package, in bytes, if the installed version is in a sync database; 0 otherwise"),
+	extcommon.BoolColumn(ColumnHasScriptlet, localPackage.hasScriptlet).Describe("Set to 1 if the package has an install scriptlet"),
 }
 
 type filesColumnsCtx = struct {
@@ -47,151 +149,199 @@ type filesColumnsCtx = struct {
 	f alpm.File
 }
 
-var filesColumns = []columnDef[filesColumnsCtx]{
-	stringColumn[filesColumnsCtx]{ColumnPackage, func(c filesColumnsCtx) string { return c.p.Name() }},
-	stringColumn[filesColumnsCtx]{ColumnPath, func(c filesColumnsCtx) string { return c.f.Name }},
-	intColumn[filesColumnsCtx]{ColumnSize, func(c filesColumnsCtx) int64 { return c.f.Size }},
+var filesTable = extcommon.Table[filesColumnsCtx]{
+	extcommon.TextColumn(ColumnPackage, func(c filesColumnsCtx) string { return c.p.Name() }).Describe("Name of the package owning the file").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnPath, func(c filesColumnsCtx) string { return c.f.Name }).Describe("Path of the file, relative to the root directory"),
+	extcommon.BigIntColumn(ColumnSize, func(c filesColumnsCtx) int64 { return c.f.Size }).Describe("Size of the file, in bytes"),
 }
 
 // PackagesSchema returns the schema for the "pacman_packages" table.
-func PackagesSchema() (out []table.ColumnDefinition) {
-	for _, c := range packagesColumns {
-		out = append(out, c.def())
-	}
-	return
+func PackagesSchema() []table.ColumnDefinition {
+	return packagesTable.Schema()
+}
+
+// PackagesStream generates row data for the "pacman_packages" table.
+func PackagesStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	tbl := packagesTable.ForQuery(ctx, q)
+	return forEachPackage(ctx, func(pkg localPackage) error {
+		if more, err := tbl.Yield(q, pkg, yield); !more {
+			return stopIteration(err)
+		}
+		return nil
+	})
 }
 
 // PackagesGenerate generates row data for the "pacman_packages" table.
+//
+// Deprecated: the table is registered with extcommon.RegisterTable, which streams its rows and
+// coalesces identical queries, so serve it from extcommon.RegisteredTables, or use PackagesStream.
 func PackagesGenerate(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
-	h, err := handle()
-	if err != nil {
-		return nil, err
-	}
-	defer release()
+	return collect(ctx, q, PackagesStream)
+}
 
-	db, err := h.LocalDB()
-	if err != nil {
-		return nil, err
-	}
+// FilesSchema returns the schema for the "pacman_files" table.
+func FilesSchema() []table.ColumnDefinition {
+	return filesTable.Schema()
+}
 
-	var out []map[string]string
-	err = db.PkgCache().ForEach(func(pkg alpm.IPackage) error {
-		row := make(map[string]string)
-		for _, col := range packagesColumns {
-			if constraints, ok := q.Constraints[col.name()]; ok {
-				if m, err := col.matches(pkg, constraints); !m {
-					return err
-				}
-			}
-			rawValue := col.val(pkg)
-			switch v := rawValue.(type) {
-			case string:
-				row[col.name()] = v
-			case int, int64, uint, uint64:
-				row[col.name()] = fmt.Sprintf("%d", v)
-			case bool:
-				row[col.name()] = "0"
-				if v {
-					row[col.name()] = "1"
-				}
+// FilesStream generates row data for the "pacman_files" table.
+func FilesStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	tbl := filesTable.ForQuery(ctx, q)
+	return forEachPackage(ctx, func(pkg localPackage) error {
+		// filter on package name before iterating the files, which is computationally expensive
+		if m, err := filesTable.Matches(q, filesColumnsCtx{pkg, alpm.File{}}, ColumnPackage); !m {
+			return err
+		}
+
+		for _, f := range pkg.Files() {
+			if more, err := tbl.Yield(q, filesColumnsCtx{pkg, f}, yield); !more {
+				return stopIteration(err)
 			}
 		}
-		out = append(out, row)
 		return nil
 	})
+}
 
-	return out, err
+// FilesGenerate generates row data for the "pacman_files" table.
+//
+// Deprecated: the table is registered with extcommon.RegisterTable, which streams its rows and
+// coalesces identical queries, so serve it from extcommon.RegisteredTables, or use FilesStream.
+func FilesGenerate(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
+	return collect(ctx, q, FilesStream)
 }
 
-// FilesSchema returns the schema for the "pacman_files" table.
-func FilesSchema() (out []table.ColumnDefinition) {
-	for _, c := range filesColumns {
-		out = append(out, c.def())
-	}
+// collect returns every row generated by a stream function.
+func collect(ctx context.Context, q table.QueryContext, stream extcommon.StreamFunc) (out []map[string]string, err error) {
+	err = stream(ctx, q, func(row map[string]string) bool {
+		out = append(out, row)
+		return true
+	})
 	return
 }
 
-// FilesGenerate generates row data for the "pacman_files" table.
-func FilesGenerate(ctx context.Context, q table.QueryContext) ([]map[string]string, error) {
-	h, err := handle()
+// forEachPackage calls f with each package of the local database, until f returns an error,
+// holding alpmLock throughout.
+// f returns errStop, via stopIteration, once no more rows are needed.
+func forEachPackage(ctx context.Context, f func(localPackage) error) (err error) {
+	h, err := handle(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer func() { err = h.release(err) }()
+	if err := lockAlpm(ctx); err != nil {
+		return err
 	}
-	defer release()
+	defer unlockAlpm()
 
 	db, err := h.LocalDB()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var out []map[string]string
 	err = db.PkgCache().ForEach(func(pkg alpm.IPackage) error {
-		// filter on package name before iterating the files, which is computationally expensive
-		if c, ok := q.Constraints[ColumnPackage]; ok {
-			if m, err := filesColumns[0].matches(filesColumnsCtx{pkg, alpm.File{}}, c); !m {
-				return err
-			}
-		}
-
-		for _, f := range pkg.Files() {
-			row := make(map[string]string)
-			for i, col := range filesColumns {
-				ctx := filesColumnsCtx{pkg, f}
-				// skip filtering on the `package` column, we did this above before iterating Files
-				if i > 0 {
-					if constraints, ok := q.Constraints[col.name()]; ok {
-						if m, err := col.matches(ctx, constraints); !m {
-							return err
-						}
-					}
-				}
-				rawValue := col.val(ctx)
-				switch v := rawValue.(type) {
-				case string:
-					row[col.name()] = v
-				case int, int64, uint, uint64:
-					row[col.name()] = fmt.Sprintf("%d", v)
-				case bool:
-					row[col.name()] = "0"
-					if v {
-						row[col.name()] = "1"
-					}
-				}
-			}
-			out = append(out, row)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		return nil
+		return f(localPackage{pkg, h})
 	})
-
-	return out, err
+	return ignoreStop(err)
 }
 
-func handle() (*alpm.Handle, error) {
-	var err error
+// concurrencyGroup serializes queries against the pacman tables, since libalpm loads package
+// data lazily and isn't safe for concurrent use.
+const concurrencyGroup = "alpm"
 
-	hMu.Lock()
-	defer hMu.Unlock()
+// errStop is returned from package iteration callbacks to stop iterating once no more rows are
+// needed.
+var errStop = errors.New("stop iteration")
 
-	if h != nil {
-		return h, nil
+// stopIteration returns err, or errStop if the iteration stopped because no more rows are needed.
+func stopIteration(err error) error {
+	if err == nil {
+		return errStop
 	}
-
-	h, err = alpm.Initialize("/", dbPath)
-	return h, err
+	return err
 }
 
-func release() {
-	hMu.Lock()
-	defer hMu.Unlock()
-
-	if h == nil {
-		return
+func ignoreStop(err error) error {
+	if errors.Is(err, errStop) {
+		return nil
 	}
+	return err
+}
 
-	h.Release()
-	h = nil
+// CacheStamp returns a cache stamp which changes whenever a package is installed, upgraded or
+// removed.
+func CacheStamp(table.QueryContext) (string, error) {
+	return dbStamp(currentSettings())
 }
 
 func init() {
-	flag.StringVar(&dbPath, "pacman.db-path", dbPath, "path to pacman database")
+	flag.StringVar(&configPath, "pacman.config", configPath, "path to pacman config file")
+	flag.StringVar(&rootDir, "pacman.root", "", "root directory of pacman installations; defaults to RootDir in the pacman config, or "+defaultRootDir)
+	flag.StringVar(&dbPath, "pacman.db-path", "", "path to pacman database; defaults to DBPath in the pacman config, or "+defaultDBPath)
+	flag.StringVar(&logPath, "pacman.log-path", "", "path to pacman log file; defaults to LogFile in the pacman config, or "+defaultLogFile)
+
+	cache := &extcommon.CacheSpec{Stamp: CacheStamp}
+	spec := func(description string, columns func() []extcommon.ColumnInfo, stream extcommon.StreamFunc) extcommon.TableSpec {
+		return extcommon.TableSpec{
+			Description:      description,
+			Columns:          columns,
+			Stream:           stream,
+			Cache:            cache,
+			MaxConcurrency:   1,
+			ConcurrencyGroup: concurrencyGroup,
+			Coalesce:         true,
+		}
+	}
+	extcommon.RegisterTable("pacman_packages",
+		spec("Packages installed by pacman.", packagesTable.Columns, PackagesStream))
+	extcommon.RegisterTable("pacman_files",
+		spec("Files installed by pacman packages.", filesTable.Columns, FilesStream))
+	extcommon.RegisterTable("pacman_package_dependencies",
+		spec("Relations of installed pacman packages to other packages, such as their dependencies.",
+			dependenciesTable.Columns, DependenciesStream))
+	extcommon.RegisterTable("pacman_sync_packages",
+		spec("Packages of the sync databases last downloaded by pacman -Sy.", syncPackagesTable.Columns, SyncPackagesStream))
+	extcommon.RegisterTable("pacman_updates",
+		spec("Installed pacman packages with a newer version in the sync databases last downloaded by pacman -Sy.",
+			updatesTable.Columns, UpdatesStream))
+
+	integrity := spec(
+		"Files installed by pacman packages, compared with their state when they were installed like pacman -Qkk.",
+		integrityTable.Columns, IntegrityStream)
+	backupFiles := spec("Configuration files of pacman packages, and whether they were modified.",
+		backupFilesTable.Columns, BackupFilesStream)
+	// the files may change without the database changing, so they're read by every query
+	integrity.Cache = nil
+	backupFiles.Cache = nil
+	// verifying every file may take minutes, so queries of the other tables don't wait for it;
+	// it only holds alpmLock while listing packages
+	integrity.ConcurrencyGroup = ""
+	extcommon.RegisterTable("pacman_file_integrity", integrity)
+	extcommon.RegisterTable("pacman_backup_files", backupFiles)
+
+	// pacman.conf and the log are read without libalpm, so queries don't wait for those of the
+	// other tables
+	configCache := &extcommon.CacheSpec{Stamp: ConfigCacheStamp}
+	extcommon.RegisterTable("pacman_config", extcommon.TableSpec{
+		Description: "Options of the [options] section of pacman.conf, including those of included files.",
+		Columns:     configTable.Columns,
+		Stream:      ConfigStream,
+		Cache:       configCache,
+	})
+	extcommon.RegisterTable("pacman_repositories", extcommon.TableSpec{
+		Description: "Repositories configured in pacman.conf.",
+		Columns:     repositoriesTable.Columns,
+		Stream:      RepositoriesStream,
+		Cache:       configCache,
+	})
+	extcommon.RegisterTable("pacman_log", extcommon.TableSpec{
+		Description: "Entries of the pacman log, including its rotated copies, with the package actions of transactions parsed.",
+		Columns:     logTable.Columns,
+		Stream:      LogStream,
+		Cache:       &extcommon.CacheSpec{Stamp: LogCacheStamp},
+		Coalesce:    true,
+	})
 }
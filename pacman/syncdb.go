@@ -0,0 +1,145 @@
+package pacman
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Jguer/go-alpm/v2"
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
+)
+
+const (
+	ColumnInstalledSize    = "installed_size"
+	ColumnInstalledVersion = "installed_version"
+	ColumnAvailableVersion = "available_version"
+	ColumnIgnored          = "ignored"
+)
+
+// usages maps the values of the Usage option of pacman.conf to the usages of sync databases.
+var usages = map[string]alpm.Usage{
+	"Sync":    alpm.UsageSync,
+	"Search":  alpm.UsageSearch,
+	"Install": alpm.UsageInstall,
+	"Upgrade": alpm.UsageUpgrade,
+	"All":     alpm.UsageAll,
+}
+
+// repositoryUsage returns the usage of a repository of pacman.conf, which is every usage if the
+// repository doesn't set any.
+func repositoryUsage(r *repository) alpm.Usage {
+	if len(r.usage) == 0 {
+		return alpm.UsageAll
+	}
+	var u alpm.Usage
+	for _, name := range r.usage {
+		u |= usages[name]
+	}
+	return u
+}
+
+var syncPackagesTable = extcommon.Table[alpm.IPackage]{
+	extcommon.TextColumn(ColumnRepository, func(p alpm.IPackage) string { return p.DB().Name() }).
+		Describe("Name of the sync database the package is in").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnName, alpm.IPackage.Name).Describe("Name of the package").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnVersion, alpm.IPackage.Version).CompareWith(alpm.VerCmp).Describe("Version of the package. Comparisons use pacman's version ordering"),
+	extcommon.TextColumn(ColumnDescription, alpm.IPackage.Description).Describe("Description of the package"),
+	extcommon.TextColumn(ColumnArchitecture, alpm.IPackage.Architecture).Describe("Architecture the package was built for"),
+	extcommon.TextColumn(ColumnUrl, alpm.IPackage.URL).Describe("URL of the upstream project"),
+	extcommon.TextColumn(ColumnLicense, func(p alpm.IPackage) string { return strings.Join(p.Licenses().Slice(), ",") }).Describe("Comma-separated list of licenses of the package"),
+	extcommon.TextColumn(ColumnGroups, func(p alpm.IPackage) string { return strings.Join(p.Groups().Slice(), ",") }).Describe("Comma-separated list of groups the package belongs to"),
+	extcommon.TextColumn(ColumnPackager, alpm.IPackage.Packager).Describe("Name and email address of the packager"),
+	extcommon.BigIntColumn(ColumnBuildDate, func(p alpm.IPackage) int64 { return p.BuildDate().Unix() }).Describe("Time the package was built, in seconds since the Unix epoch"),
+	extcommon.BigIntColumn(ColumnDownloadSize, alpm.IPackage.Size).Describe("Compressed size of the package, in bytes"),
+	extcommon.BigIntColumn(ColumnInstalledSize, alpm.IPackage.ISize).Describe("Installed size of the package, in bytes"),
+}
+
+// SyncPackagesStream generates row data for the "pacman_sync_packages" table.
+func SyncPackagesStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) (err error) {
+	h, err := handle(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = h.release(err) }()
+	if err := lockAlpm(ctx); err != nil {
+		return err
+	}
+	defer unlockAlpm()
+
+	tbl := syncPackagesTable.ForQuery(ctx, q)
+	err = h.syncDBs.ForEach(func(db alpm.IDB) error {
+		// skip the databases the query doesn't select before loading them
+		if m, err := extcommon.MatchConstraints(extcommon.TextValue{V: db.Name()}, q.Constraints[ColumnRepository]); err != nil {
+			return err
+		} else if !m {
+			return nil
+		}
+
+		// look packages up by name rather than listing the whole database when possible
+		if names := extcommon.EqualityConstraints(q, ColumnName); len(names) > 0 {
+			for _, name := range names {
+				if pkg := db.Pkg(name); pkg != nil {
+					if more, err := tbl.Yield(q, pkg, yield); !more {
+						return stopIteration(err)
+					}
+				}
+			}
+			return nil
+		}
+
+		return db.PkgCache().ForEach(func(pkg alpm.IPackage) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if more, err := tbl.Yield(q, pkg, yield); !more {
+				return stopIteration(err)
+			}
+			return nil
+		})
+	})
+	return ignoreStop(err)
+}
+
+// update is an installed package with a newer version in the sync databases.
+type update struct {
+	pkg       localPackage
+	available alpm.IPackage
+}
+
+var updatesTable = extcommon.Table[update]{
+	extcommon.TextColumn(ColumnName, func(u update) string { return u.pkg.Name() }).
+		Describe("Name of the package").
+		WithOptions(extcommon.ColumnIndex),
+	extcommon.TextColumn(ColumnInstalledVersion, func(u update) string { return u.pkg.Version() }).
+		CompareWith(alpm.VerCmp).
+		Describe("Installed version of the package"),
+	extcommon.TextColumn(ColumnAvailableVersion, func(u update) string { return u.available.Version() }).
+		CompareWith(alpm.VerCmp).
+		Describe("Newer version of the package in the sync databases"),
+	extcommon.TextColumn(ColumnRepository, func(u update) string { return u.available.DB().Name() }).
+		Describe("Name of the sync database with the newer version"),
+	extcommon.BoolColumn(ColumnIgnored, func(u update) bool { return u.available.ShouldIgnore() }).
+		Describe("Set to 1 if pacman -Syu skips the update, because of the IgnorePkg or IgnoreGroup options"),
+}
+
+// UpdatesStream generates row data for the "pacman_updates" table.
+func UpdatesStream(ctx context.Context, q table.QueryContext, yield extcommon.YieldFunc) error {
+	tbl := updatesTable.ForQuery(ctx, q)
+	return forEachPackage(ctx, func(pkg localPackage) error {
+		if m, err := updatesTable.Matches(q, update{pkg: pkg}, ColumnName); !m {
+			return err
+		}
+
+		// like pacman -Qu, only the first sync database with the package is considered
+		available := pkg.SyncNewVersion(pkg.h.syncDBs)
+		if available == nil {
+			return nil
+		}
+		if more, err := tbl.Yield(q, update{pkg, available}, yield); !more {
+			return stopIteration(err)
+		}
+		return nil
+	})
+}
@@ -12,13 +12,12 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/osquery/osquery-go/plugin/table"
+	"go.fuhry.dev/osquery/extcommon"
 )
 
 const (
@@ -43,100 +42,163 @@ const (
 	ColumnThumbprintSHA256 = "sha256_thumbprint"
 )
 
-func Schema() (out []table.ColumnDefinition) {
-	return []table.ColumnDefinition{
-		table.TextColumn(ColumnPath),
-		table.BigIntColumn(ColumnIndex),
-		table.TextColumn(ColumnError),
-		table.TextColumn(ColumnEncoding),
-		table.TextColumn(ColumnSubject),
-		table.TextColumn(ColumnIssuer),
-		table.TextColumn(ColumnSerial),
-		table.IntegerColumn(ColumnIsCA),
-		table.TextColumn(ColumnPublicKey),
-		table.TextColumn(ColumnPublicKeyAlg),
-		table.IntegerColumn(ColumnPublicKeyBits),
-		table.TextColumn(ColumnAltNames),
-		table.BigIntColumn(ColumnNotBefore),
-		table.BigIntColumn(ColumnNotAfter),
-		table.IntegerColumn(ColumnSecondsRemaining),
-		table.IntegerColumn(ColumnPercentRemaining),
-		table.IntegerColumn(ColumnValidNow),
-		table.TextColumn(ColumnThumbprintSHA1),
-		table.TextColumn(ColumnThumbprintSHA256),
-	}
+// certEntry is a single certificate, or an error encountered while looking for one, at a given
+// position in a file.
+type certEntry struct {
+	path     string
+	index    int
+	err      string
+	encoding string
+	cert     *x509.Certificate
+	now      time.Time
+}
+
+var certsTable = extcommon.Table[*certEntry]{
+	extcommon.TextColumn(ColumnPath, func(e *certEntry) string { return e.path }).Describe("Absolute path of the certificate file").
+		WithOptions(extcommon.ColumnRequired),
+	extcommon.BigIntColumn(ColumnIndex, func(e *certEntry) int64 { return int64(e.index) }).Describe("Indicates the certificate's position in the file"),
+	extcommon.TextColumn(ColumnError, func(e *certEntry) string { return e.err }).Describe("Set to non-empty string if there was an error parsing the certificate"),
+	extcommon.TextColumn(ColumnEncoding, func(e *certEntry) string { return e.encoding }).Describe(`"DER" or "PEM"`),
+	extcommon.TextColumn(ColumnSubject, certString(func(c *x509.Certificate) string { return c.Subject.String() })).Describe("X.500 string-encoded subject name"),
+	extcommon.TextColumn(ColumnIssuer, certString(func(c *x509.Certificate) string { return c.Issuer.String() })).Describe("X.500 string-encoded issuer name"),
+	extcommon.TextColumn(ColumnSerial, certString(func(c *x509.Certificate) string {
+		return hex.EncodeToString(c.SerialNumber.Bytes())
+	})).Describe("Hex-encoded serial number of the certificate"),
+	extcommon.BoolColumn(ColumnIsCA, func(e *certEntry) bool { return e.cert != nil && e.cert.IsCA }).Describe(`Set to 1 if the certificate has the "CA" flag set, 0 otherwise`),
+	extcommon.TextColumn(ColumnPublicKey, certString(publicKeyPEM)).Describe("PKCS#8-encoded public key of the certificate"),
+	extcommon.TextColumn(ColumnPublicKeyAlg, func(e *certEntry) string {
+		alg, _ := e.publicKeyInfo()
+		return alg
+	}).Describe(`"RSA" and "ECDSA" are currently supported; empty string otherwise`),
+	extcommon.IntegerColumn(ColumnPublicKeyBits, func(e *certEntry) int64 {
+		_, bits := e.publicKeyInfo()
+		return int64(bits)
+	}).Describe("Normalized length of the public key, in bits"),
+	extcommon.TextColumn(ColumnAltNames, certString(altNames)).Describe(`Comma-separated list of subject alternative names. Each will have one of the following prefixes: "DNS:", "MAIL:", "IP:" or "URI:". Order is not guaranteed.`),
+	extcommon.NewColumn(table.BigIntColumn(ColumnNotBefore), certTime(func(c *x509.Certificate) time.Time {
+		return c.NotBefore
+	})).Describe("Seconds since epoch of when the certificate becomes valid"),
+	extcommon.NewColumn(table.BigIntColumn(ColumnNotAfter), certTime(func(c *x509.Certificate) time.Time {
+		return c.NotAfter
+	})).Describe("Seconds since epoch of when the certificate expires"),
+	extcommon.IntegerColumn(ColumnSecondsRemaining, func(e *certEntry) int64 {
+		if !e.validNow() {
+			return 0
+		}
+		return e.cert.NotAfter.Unix() - e.now.Unix()
+	}).Describe("Seconds until the certificate expires. Set to 0 if the certificate is expired or not valid yet."),
+	extcommon.IntegerColumn(ColumnPercentRemaining, func(e *certEntry) int64 {
+		if !e.validNow() {
+			return 0
+		}
+		ttl := e.cert.NotAfter.Unix() - e.cert.NotBefore.Unix()
+		if ttl <= 0 {
+			// the validity period is shorter than a second
+			return 0
+		}
+		remain := e.cert.NotAfter.Unix() - e.now.Unix()
+		return (remain * 100) / ttl
+	}).Describe("Percentage of time remaining in the certificate's validity period. Set to 0 if the certificate is expired or not valid yet."),
+	extcommon.BoolColumn(ColumnValidNow, (*certEntry).validNow).Describe("Set to 1 if the current time is between the certificate's NotBefore and NotAfter timestamps; 0 otherwise."),
+	extcommon.TextColumn(ColumnThumbprintSHA1, certString(func(c *x509.Certificate) string {
+		sum := sha1.Sum(c.Raw)
+		return hex.EncodeToString(sum[:])
+	})).Describe("Hex-encoded SHA-1 digest of the certificate's DER-encoded form."),
+	extcommon.TextColumn(ColumnThumbprintSHA256, certString(func(c *x509.Certificate) string {
+		sum := sha256.Sum256(c.Raw)
+		return hex.EncodeToString(sum[:])
+	})).Describe("Hex-encoded SHA-256 digest of the certificate's DER-encoded form."),
+}
+
+func Schema() []table.ColumnDefinition {
+	return certsTable.Schema()
 }
 
-var ErrMissingRequiredColumn = errors.New("missing required column in WHERE clause \"path\"")
+// ErrMissingRequiredColumn is returned for queries without a "path" constraint.
+//
+// Deprecated: the path column is marked as required, so such queries are rejected by extcommon
+// with extcommon.ErrMissingRequiredColumn before Generate is called.
+var ErrMissingRequiredColumn = extcommon.ErrMissingRequiredColumn
 var ErrUnsupportedColumnOperator = errors.New("unsupported operator in WHERE clause")
 
 func Generate(ctx context.Context, q table.QueryContext) (out []map[string]string, err error) {
-	if _, ok := q.Constraints[ColumnPath]; !ok {
-		err = ErrMissingRequiredColumn
-		return
-	}
-
+	tbl := certsTable.ForQuery(ctx, q)
 	for _, c := range q.Constraints[ColumnPath].Constraints {
 		if c.Operator != table.OperatorEquals {
 			err = ErrUnsupportedColumnOperator
 			return
 		}
 
-		out = append(out, generateRows(c.Expression)...)
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		for _, e := range parseFile(c.Expression) {
+			if e.err != "" {
+				extcommon.Logger(ctx).Debug("failed to parse certificate", "path", e.path, "index", e.index, "error", e.err)
+			}
+			row, ok, err := tbl.Row(q, e)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out = append(out, row)
+			}
+		}
 	}
 
 	return out, err
 }
 
-func newRow(certPath string, index int) map[string]string {
-	return map[string]string{
-		ColumnPath:             certPath,
-		ColumnIndex:            fmt.Sprintf("%d", index),
-		ColumnError:            "",
-		ColumnEncoding:         "DER",
-		ColumnSubject:          "",
-		ColumnIssuer:           "",
-		ColumnSerial:           "",
-		ColumnIsCA:             "0",
-		ColumnPublicKey:        "",
-		ColumnPublicKeyAlg:     "",
-		ColumnPublicKeyBits:    "0",
-		ColumnAltNames:         "",
-		ColumnNotBefore:        "",
-		ColumnNotAfter:         "",
-		ColumnSecondsRemaining: "0",
-		ColumnValidNow:         "0",
-		ColumnThumbprintSHA1:   "",
-		ColumnThumbprintSHA256: "",
-	}
+// CacheStamp returns a cache stamp which changes whenever any of the certificate files named in
+// the query are modified.
+func CacheStamp(q table.QueryContext) (string, error) {
+	return extcommon.FileStamp(extcommon.EqualityConstraints(q, ColumnPath)...)
 }
 
 func generateRows(certPath string) (out []map[string]string) {
-	row := newRow(certPath, 0)
+	for _, e := range parseFile(certPath) {
+		row, _, _ := certsTable.Row(table.QueryContext{}, e)
+		out = append(out, row)
+	}
+	return
+}
+
+func newEntry(certPath string, index int) *certEntry {
+	return &certEntry{
+		path:     certPath,
+		index:    index,
+		encoding: "DER",
+		now:      time.Now(),
+	}
+}
+
+func parseFile(certPath string) (out []*certEntry) {
+	entry := newEntry(certPath, 0)
 
 	stat, err := os.Stat(certPath)
 	if err != nil {
-		row[ColumnError] = err.Error()
-		out = append(out, row)
+		entry.err = err.Error()
+		out = append(out, entry)
 		return
 	}
 
 	if !stat.Mode().IsRegular() {
-		row[ColumnError] = "not a regular file"
-		out = append(out, row)
+		entry.err = "not a regular file"
+		out = append(out, entry)
 		return
 	}
 
 	contents, err := os.ReadFile(certPath)
 	if err != nil {
-		row[ColumnError] = err.Error()
-		out = append(out, row)
+		entry.err = err.Error()
+		out = append(out, entry)
 		return
 	}
 
 	if strings.TrimSpace(string(contents)) == "" {
-		row[ColumnError] = "file is empty"
-		out = append(out, row)
+		entry.err = "file is empty"
+		out = append(out, entry)
 		return
 	}
 
@@ -147,7 +209,7 @@ outer:
 			return
 		}
 
-		row = newRow(certPath, i)
+		entry = newEntry(certPath, i)
 
 		cert, err := x509.ParseCertificate(contents)
 		if err == nil {
@@ -159,8 +221,8 @@ outer:
 				block, remainder := pem.Decode(contents)
 				if block == nil && bytes.Equal(remainder, contents) {
 					if i == 0 {
-						row[ColumnError] = "unable to decode contents as PEM or DER"
-						out = append(out, row)
+						entry.err = "unable to decode contents as PEM or DER"
+						out = append(out, entry)
 					}
 					return
 				}
@@ -171,80 +233,101 @@ outer:
 
 				cert, err = x509.ParseCertificate(block.Bytes)
 				if err != nil {
-					row[ColumnError] = "PEM certificate block found, but unable to decode " +
+					entry.err = "PEM certificate block found, but unable to decode " +
 						"contents as X.509 certificate"
-					out = append(out, row)
+					out = append(out, entry)
 					continue outer
 				}
 
-				row[ColumnEncoding] = "PEM"
+				entry.encoding = "PEM"
 				break
 			}
 		}
 
 		if cert == nil {
-			row[ColumnError] = "certificate is nil after successful decode, should not reach this point"
+			entry.err = "certificate is nil after successful decode, should not reach this point"
 			return
 		}
 
-		row[ColumnSubject] = cert.Subject.String()
-		row[ColumnIssuer] = cert.Issuer.String()
-		row[ColumnSerial] = hex.EncodeToString(cert.SerialNumber.Bytes())
-		row[ColumnNotBefore] = fmt.Sprintf("%d", cert.NotBefore.Unix())
-		row[ColumnNotAfter] = fmt.Sprintf("%d", cert.NotAfter.Unix())
+		entry.cert = cert
+		out = append(out, entry)
+	}
+}
 
-		var altNames []string
-		for _, name := range cert.DNSNames {
-			altNames = append(altNames, fmt.Sprintf("DNS:%s", name))
-		}
-		for _, name := range cert.EmailAddresses {
-			altNames = append(altNames, fmt.Sprintf("MAIL:%s", name))
-		}
-		for _, name := range cert.IPAddresses {
-			altNames = append(altNames, fmt.Sprintf("IP:%s", name.String()))
-		}
-		for _, name := range cert.URIs {
-			altNames = append(altNames, fmt.Sprintf("URI:%s", name.String()))
-		}
-		row[ColumnAltNames] = strings.Join(altNames, ",")
-
-		if pubkey, err := x509.MarshalPKIXPublicKey(cert.PublicKey); err == nil {
-			row[ColumnPublicKey] = string(pem.EncodeToMemory(&pem.Block{
-				Type:  "PUBLIC KEY",
-				Bytes: pubkey,
-			}))
-			switch k := cert.PublicKey.(type) {
-			case *rsa.PublicKey:
-				row[ColumnPublicKeyAlg] = "RSA"
-				row[ColumnPublicKeyBits] = strconv.Itoa(8 * k.Size())
-			case *ecdsa.PublicKey:
-				row[ColumnPublicKeyAlg] = "ECDSA"
-				row[ColumnPublicKeyBits] = strconv.Itoa(k.Params().BitSize)
-			}
-		}
+func (e *certEntry) validNow() bool {
+	return e.cert != nil && e.now.After(e.cert.NotBefore) && e.now.Before(e.cert.NotAfter)
+}
 
-		if cert.IsCA {
-			row[ColumnIsCA] = "1"
-		}
+// publicKeyInfo returns the algorithm and normalized size in bits of the certificate's public
+// key.
+func (e *certEntry) publicKeyInfo() (string, int) {
+	if e.cert == nil {
+		return "", 0
+	}
+	switch k := e.cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", 8 * k.Size()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Params().BitSize
+	}
+	return "", 0
+}
 
-		now := time.Now()
-		if now.After(cert.NotBefore) && now.Before(cert.NotAfter) {
-			row[ColumnValidNow] = "1"
-			ttl := cert.NotAfter.Unix() - cert.NotBefore.Unix()
-			remain := cert.NotAfter.Unix() - now.Unix()
-			row[ColumnSecondsRemaining] = fmt.Sprintf("%d", remain)
-			row[ColumnPercentRemaining] = fmt.Sprintf("%d", (remain*100)/ttl)
-		}
+func publicKeyPEM(cert *x509.Certificate) string {
+	pubkey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubkey,
+	}))
+}
 
-		sha := sha1.New()
-		_, _ = io.Copy(sha, bytes.NewBuffer(cert.Raw))
-		var buf []byte
-		row[ColumnThumbprintSHA1] = hex.EncodeToString(sha.Sum(buf))
+func altNames(cert *x509.Certificate) string {
+	var altNames []string
+	for _, name := range cert.DNSNames {
+		altNames = append(altNames, fmt.Sprintf("DNS:%s", name))
+	}
+	for _, name := range cert.EmailAddresses {
+		altNames = append(altNames, fmt.Sprintf("MAIL:%s", name))
+	}
+	for _, name := range cert.IPAddresses {
+		altNames = append(altNames, fmt.Sprintf("IP:%s", name.String()))
+	}
+	for _, name := range cert.URIs {
+		altNames = append(altNames, fmt.Sprintf("URI:%s", name.String()))
+	}
+	return strings.Join(altNames, ",")
+}
 
-		sha = sha256.New()
-		_, _ = io.Copy(sha, bytes.NewBuffer(cert.Raw))
-		buf = nil
-		row[ColumnThumbprintSHA256] = hex.EncodeToString(sha.Sum(buf))
-		out = append(out, row)
+// certString adapts a function of the certificate to a column getter, which returns an empty
+// string for entries that don't have a certificate.
+func certString(get func(*x509.Certificate) string) func(*certEntry) string {
+	return func(e *certEntry) string {
+		if e.cert == nil {
+			return ""
+		}
+		return get(e.cert)
 	}
 }
+
+// certTime adapts a timestamp of the certificate to a column getter, which returns null for
+// entries that don't have a certificate.
+func certTime(get func(*x509.Certificate) time.Time) func(*certEntry) extcommon.Value {
+	return func(e *certEntry) extcommon.Value {
+		if e.cert == nil {
+			return extcommon.Null
+		}
+		return extcommon.IntValue(get(e.cert).Unix())
+	}
+}
+
+func init() {
+	extcommon.RegisterTable("x509_certificates", extcommon.TableSpec{
+		Description: "X.509 certificates found in a PEM or DER encoded file.",
+		Columns:     certsTable.Columns,
+		Generate:    Generate,
+		Cache:       &extcommon.CacheSpec{Stamp: CacheStamp},
+	})
+}
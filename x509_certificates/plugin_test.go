@@ -1,12 +1,18 @@
 package x509_certificates
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
 	"os"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/osquery/osquery-go/plugin/table"
 	"github.com/stretchr/testify/assert"
+	"go.fuhry.dev/osquery/extcommon"
+	"go.fuhry.dev/osquery/extcommon/extcommontest"
 )
 
 func TestGenerateRows(t *testing.T) {
@@ -94,3 +100,55 @@ func TestGenerateRows(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryThroughOsquery(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	dataDir := path.Join(wd, "testdata")
+
+	m := extcommontest.NewManager(t)
+	m.Serve("x509_certificates", extcommon.Tables{
+		"x509_certificates": {Columns: certsTable.Columns, Generate: Generate},
+	})
+
+	cols, err := m.Columns("x509_certificates")
+	assert.NoError(t, err)
+	assert.Equal(t, Schema(), cols)
+
+	rows, err := m.Query("x509_certificates", extcommontest.Query{
+		Constraints: map[string]table.ConstraintList{
+			ColumnPath: {Affinity: table.ColumnTypeText, Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: path.Join(dataDir, "rsa-2048.crt")},
+				{Operator: table.OperatorEquals, Expression: path.Join(dataDir, "ecdsa-p384.crt")},
+			}},
+			ColumnPublicKeyAlg: {Affinity: table.ColumnTypeText, Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: "RSA"},
+			}},
+		},
+		Columns: []string{ColumnPublicKeyBits},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{
+		ColumnPath:          path.Join(dataDir, "rsa-2048.crt"),
+		ColumnPublicKeyAlg:  "RSA",
+		ColumnPublicKeyBits: "2048",
+	}}, rows)
+
+	_, err = m.Query("x509_certificates", extcommontest.Query{})
+	assert.ErrorContains(t, err, ErrMissingRequiredColumn.Error())
+}
+
+func TestPercentRemainingEmptyValidity(t *testing.T) {
+	notBefore := time.Unix(1700000000, 100)
+	for _, notAfter := range []time.Time{notBefore, notBefore.Add(500 * time.Millisecond)} {
+		e := &certEntry{
+			cert: &x509.Certificate{NotBefore: notBefore, NotAfter: notAfter},
+			now:  notBefore.Add(time.Millisecond),
+		}
+		ctx := extcommon.WithUsedColumns(context.Background(), []string{ColumnPercentRemaining})
+		row, ok, err := certsTable.ForQuery(ctx, table.QueryContext{}).Row(table.QueryContext{}, e)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "0", row[ColumnPercentRemaining])
+	}
+}